@@ -0,0 +1,16 @@
+module github.com/HewlettPackard/structex/analysis
+
+go 1.26.0
+
+require (
+	github.com/HewlettPackard/structex v0.0.0-00010101000000-000000000000
+	golang.org/x/tools v0.50.0
+)
+
+require (
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
+)
+
+// The analyzer shares the annotation parser of the structex sources alongside it
+replace github.com/HewlettPackard/structex => ../
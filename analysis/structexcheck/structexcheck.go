@@ -0,0 +1,471 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package structexcheck defines an Analyzer that checks structex structure
+// annotations at compile time.
+//
+// The analyzer reads annotations with the same grammar as the structex
+// runtime and reports invalid bitfield sizes, sizeOf and countOf annotations
+// referencing fields that do not exist or are not arrays or slices, align on
+// bitfields, unexported fields the decoder cannot set and structures that do
+// not end on a byte boundary.
+//
+// Only structures with at least one structex annotation are checked.
+package structexcheck
+
+import (
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/HewlettPackard/structex/internal/tag"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+const doc = `check structex structure annotations
+
+The structexcheck analyzer reports structex annotations that the runtime
+would reject, or that describe a layout it cannot transcode: invalid
+bitfield sizes, sizeOf and countOf references to missing or non-array
+fields, align on bitfields, unexported fields and structures that do not
+end on a byte boundary, including any nested structures.`
+
+var Analyzer = &analysis.Analyzer{
+	Name:     "structexcheck",
+	Doc:      doc,
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+// A structure is identified by its named type if declared, otherwise by the
+// structure type itself.
+type checker struct {
+	pass    *analysis.Pass
+	parents map[types.Type][]types.Type // Structures holding a field of the type
+}
+
+type field struct {
+	node *ast.Field
+	v    *types.Var
+	tag  reflect.StructTag
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	c := checker{
+		pass:    pass,
+		parents: make(map[types.Type][]types.Type),
+	}
+
+	named := make(map[*ast.StructType]types.Type)
+	inspect.Preorder([]ast.Node{(*ast.TypeSpec)(nil)}, func(n ast.Node) {
+		spec := n.(*ast.TypeSpec)
+		if st, ok := spec.Type.(*ast.StructType); ok {
+			if obj := pass.TypesInfo.Defs[spec.Name]; obj != nil {
+				named[st] = obj.Type()
+			}
+		}
+	})
+
+	structs := []*ast.StructType{}
+	keys := []types.Type{}
+
+	inspect.Preorder([]ast.Node{(*ast.StructType)(nil)}, func(n ast.Node) {
+		node := n.(*ast.StructType)
+
+		key, ok := named[node]
+		if !ok {
+			key = pass.TypesInfo.TypeOf(node)
+		}
+		if key == nil {
+			return
+		}
+
+		if st, ok := key.Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				elem := elemOf(st.Field(i).Type())
+				c.parents[elem] = append(c.parents[elem], key)
+			}
+		}
+
+		structs = append(structs, node)
+		keys = append(keys, key)
+	})
+
+	for i, node := range structs {
+		c.check(node, keys[i])
+	}
+
+	return nil, nil
+}
+
+// check reports the problems of the annotated structure node.
+func (c *checker) check(node *ast.StructType, key types.Type) {
+	st, ok := key.Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+
+	fields := []field{}
+	annotated := false
+
+	idx := 0
+	for _, f := range node.Fields.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for j := 0; j < n && idx < st.NumFields(); j++ {
+			t := reflect.StructTag(st.Tag(idx))
+			fields = append(fields, field{node: f, v: st.Field(idx), tag: t})
+
+			for _, attr := range tag.Parse(t) {
+				annotated = annotated || tag.IsKey(attr.Key)
+			}
+			idx++
+		}
+	}
+
+	if !annotated {
+		return
+	}
+
+	for i := range fields {
+		f := &fields[i]
+
+		if f.v.Name() != "_" && !f.v.Exported() {
+			c.pass.Reportf(f.node.Pos(), "field %s is unexported and cannot be decoded", f.v.Name())
+		}
+
+		c.field(key, i, fields)
+	}
+
+	// Bitfields continue into nested structures, so structures held by
+	// another are laid out as part of it and only the outermost must end
+	// on a byte boundary.
+	if len(c.parents[key]) == 0 {
+		if nbits := structBits(st, make(map[types.Type]bool)); nbits%8 != 0 {
+			c.pass.Reportf(node.Pos(), "structure leaves %d left-over bits; structures must end on a byte boundary", nbits%8)
+		}
+	}
+}
+
+// field checks the annotations of fields[i] within the structure key.
+func (c *checker) field(key types.Type, i int, fields []field) {
+	f := &fields[i]
+	typ := f.v.Type()
+
+	elem := typ
+	switch u := typ.Underlying().(type) {
+	case *types.Array:
+		elem = u.Elem()
+	case *types.Slice:
+		elem = u.Elem()
+	}
+
+	bitfield, align := false, false
+
+	for _, attr := range tag.Parse(f.tag) {
+		switch strings.ToLower(attr.Key) {
+		case "bitfield":
+			nbs := strings.Split(attr.Value, ",")[0]
+			if len(nbs) == 0 {
+				break
+			}
+			bitfield = true
+
+			ebits, ok := basicBits(elem)
+			if !ok || isFloat(elem) || isString(elem) {
+				c.pass.Reportf(f.node.Pos(), "bitfield annotation cannot be applied to field %s of type %s", f.v.Name(), typ.String())
+				break
+			}
+			if isBool(elem) {
+				break
+			}
+
+			n, err := strconv.ParseInt(nbs, 0, 64)
+			switch {
+			case err != nil || n < 0:
+				c.pass.Reportf(f.node.Pos(), "invalid bitfield size '%s' for field %s", nbs, f.v.Name())
+			case uint64(n) > ebits:
+				c.pass.Reportf(f.node.Pos(), "bitfield of %d bits exceeds %d bit type of field %s", n, ebits, f.v.Name())
+			case n == 0:
+				c.pass.Reportf(f.node.Pos(), "zero width bitfield for field %s", f.v.Name())
+			}
+
+		case "sizeof", "countof":
+			c.layout(key, i, fields, strings.Split(attr.Value, ",")[0], strings.ToLower(attr.Key) == "sizeof")
+
+		case "greedy", "rest":
+			if _, ok := typ.Underlying().(*types.Slice); !ok {
+				c.pass.Reportf(f.node.Pos(), "greedy annotation cannot be applied to field %s of type %s", f.v.Name(), typ.String())
+			} else if i != len(fields)-1 {
+				c.pass.Reportf(f.node.Pos(), "greedy slice %s must be the last field", f.v.Name())
+			}
+
+		case "until", "terminator":
+			if _, ok := typ.Underlying().(*types.Slice); !ok {
+				c.pass.Reportf(f.node.Pos(), "%s annotation cannot be applied to field %s of type %s", strings.ToLower(attr.Key), f.v.Name(), typ.String())
+			}
+
+		case "align":
+			align = true
+			if n, err := strconv.ParseInt(attr.Value, 0, 64); err != nil || n < 0 {
+				c.pass.Reportf(f.node.Pos(), "invalid alignment '%s' for field %s", attr.Value, f.v.Name())
+			}
+		}
+	}
+
+	if bitfield && align {
+		c.pass.Reportf(f.node.Pos(), "align cannot be applied to bitfield %s", f.v.Name())
+	}
+}
+
+// structBits returns the number of bits laid out by the structure st,
+// excluding fields of unknown size: slices, unions, values at an offset and
+// types transcoding themselves, which all occupy whole bytes.
+func structBits(st *types.Struct, seen map[types.Type]bool) uint64 {
+	nbits := uint64(0)
+	for i := 0; i < st.NumFields(); i++ {
+		nbits = fieldBits(st.Field(i).Type(), reflect.StructTag(st.Tag(i)), nbits, seen)
+	}
+	return nbits
+}
+
+// fieldBits returns the number of bits laid out once a field of typ
+// annotated with st follows the nbits laid out so far.
+func fieldBits(typ types.Type, st reflect.StructTag, nbits uint64, seen map[types.Type]bool) uint64 {
+	for _, attr := range tag.Parse(st) {
+		switch strings.ToLower(attr.Key) {
+		case "offset":
+			return nbits
+		case "align":
+			nbits = (nbits + 7) / 8 * 8
+		}
+	}
+
+	n := uint64(1)
+	if u, ok := typ.Underlying().(*types.Array); ok {
+		n, typ = uint64(u.Len()), u.Elem()
+	}
+	for {
+		p, ok := typ.Underlying().(*types.Pointer)
+		if !ok {
+			break
+		}
+		typ = p.Elem()
+	}
+
+	if isCustom(typ) {
+		return nbits
+	}
+
+	switch u := typ.Underlying().(type) {
+	case *types.Struct:
+		if seen[typ] {
+			return nbits
+		}
+		seen[typ] = true
+		defer delete(seen, typ)
+
+		return nbits + n*structBits(u, seen)
+
+	case *types.Basic:
+		return nbits + n*scalarBits(typ, st)
+	}
+
+	return nbits
+}
+
+// scalarBits returns the size in bits of a value of the basic type typ
+// annotated with st.
+func scalarBits(typ types.Type, st reflect.StructTag) uint64 {
+	nbits, _ := basicBits(typ)
+
+	for _, attr := range tag.Parse(st) {
+		switch strings.ToLower(attr.Key) {
+		case "bitfield":
+			n, err := strconv.ParseUint(strings.Split(attr.Value, ",")[0], 0, 64)
+			if err == nil && n != 0 && n <= nbits && !isBool(typ) {
+				nbits = n
+			}
+
+		case "float":
+			if isFloat(typ) {
+				nbits = 16
+			}
+
+		case "string":
+			if w, err := strconv.ParseUint(strings.Split(attr.Value, ",")[0], 0, 64); err == nil && isString(typ) {
+				nbits = w * 8
+			}
+		}
+	}
+
+	return nbits
+}
+
+// isCustom reports if values of typ transcode themselves, and so occupy
+// whole bytes.
+func isCustom(typ types.Type) bool {
+	ms := types.NewMethodSet(types.NewPointer(typ))
+	return ms.Lookup(nil, "MarshalStructex") != nil || ms.Lookup(nil, "UnmarshalStructex") != nil
+}
+
+// layout checks the field referenced by a sizeOf or countOf annotation on
+// fields[i]. References not found in the structure itself are resolved
+// against the structures of the package holding it.
+func (c *checker) layout(key types.Type, i int, fields []field, name string, sizeOf bool) {
+	f := &fields[i]
+
+	if _, ok := basicBits(f.v.Type()); !ok || isFloat(f.v.Type()) || isString(f.v.Type()) || isBool(f.v.Type()) {
+		c.pass.Reportf(f.node.Pos(), "layout field %s must be of integer type", f.v.Name())
+	}
+
+	if name == "$rest" {
+		if !sizeOf {
+			c.pass.Reportf(f.node.Pos(), "remainder of the structure can only be given by sizeOf, not %s", f.v.Name())
+		}
+		return
+	}
+
+	var target *types.Var
+	for j := range fields {
+		if fields[j].v.Name() == name {
+			target = fields[j].v
+			switch fields[j].v.Type().Underlying().(type) {
+			case *types.Slice:
+				if j < i {
+					c.pass.Reportf(f.node.Pos(), "field %s must precede the slice %s it describes", f.v.Name(), name)
+				}
+			case *types.Struct, *types.Pointer, *types.Interface:
+				if sizeOf && j < i {
+					c.pass.Reportf(f.node.Pos(), "field %s must precede the structure %s it describes", f.v.Name(), name)
+				}
+			}
+		}
+	}
+
+	if target == nil {
+		target = c.lookup(name, key, make(map[types.Type]bool))
+	}
+
+	if target == nil {
+		c.pass.Reportf(f.node.Pos(), "cannot locate field '%s' referenced by %s", name, f.v.Name())
+		return
+	}
+
+	switch target.Type().Underlying().(type) {
+	case *types.Array, *types.Slice:
+		return
+	case *types.Struct, *types.Pointer, *types.Interface:
+		if sizeOf {
+			return
+		}
+	}
+	c.pass.Reportf(f.node.Pos(), "field '%s' referenced by %s must be of type slice or array; is of type %s", name, f.v.Name(), target.Type().String())
+}
+
+// lookup searches the structures enclosing key for the named field.
+func (c *checker) lookup(name string, key types.Type, seen map[types.Type]bool) *types.Var {
+	for _, p := range c.parents[key] {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		st := p.Underlying().(*types.Struct)
+		for i := 0; i < st.NumFields(); i++ {
+			if st.Field(i).Name() == name {
+				return st.Field(i)
+			}
+		}
+
+		if v := c.lookup(name, p, seen); v != nil {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// elemOf returns the element type of arrays, slices and pointers.
+func elemOf(typ types.Type) types.Type {
+	for {
+		switch u := typ.(type) {
+		case *types.Array:
+			typ = u.Elem()
+		case *types.Slice:
+			typ = u.Elem()
+		case *types.Pointer:
+			typ = u.Elem()
+		default:
+			return typ
+		}
+	}
+}
+
+// basicBits returns the natural size in bits of basic types.
+func basicBits(typ types.Type) (uint64, bool) {
+	b, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return 0, false
+	}
+
+	switch b.Kind() {
+	case types.Bool:
+		return 1, true
+	case types.Int8, types.Uint8:
+		return 8, true
+	case types.Int16, types.Uint16:
+		return 16, true
+	case types.Int32, types.Uint32, types.Float32:
+		return 32, true
+	case types.Int, types.Uint, types.Int64, types.Uint64, types.Float64:
+		return 64, true
+	case types.String:
+		return 0, true
+	}
+
+	return 0, false
+}
+
+func isBool(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Bool
+}
+
+func isFloat(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsFloat != 0
+}
+
+func isString(typ types.Type) bool {
+	b, ok := typ.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.String
+}
@@ -0,0 +1,73 @@
+package a
+
+type Valid struct {
+	Version     uint8  `bitfield:"4"`
+	Flags       uint8  `bitfield:"4"`
+	Count       uint8  `countOf:"Descriptors"`
+	Size        uint16 `structex:"sizeOf='Data,relative'"`
+	Descriptors []Descriptor
+	Data        []uint8 `align:"4"`
+	Name        string  `string:"4"`
+}
+
+type Nested struct {
+	Length uint16 `sizeOf:"Header"`
+	Header Descriptor
+	Rest   uint16 `sizeOf:"$rest"`
+	Data   []uint8
+}
+
+type Log struct {
+	Count   uint8
+	Entries []Descriptor `greedy:""`
+}
+
+type Table struct {
+	Entries []Descriptor `until:"Type==7,include"`
+	Codes   []uint8      `terminator:"0xFF"`
+}
+
+type Descriptor struct {
+	Type   uint8 `bitfield:"3"`
+	Length uint8 `bitfield:"5"`
+	Offset uint8 `sizeOf:"Data"` // Resolved against Valid
+}
+
+type Plain struct {
+	a uint8
+	B uint8 `json:"b"`
+}
+
+type Invalid struct { // want `structure leaves 7 left-over bits`
+	A uint8   `bitfield:"9"`           // want `bitfield of 9 bits exceeds 8 bit type of field A`
+	B uint8   `bitfield:"x"`           // want `invalid bitfield size 'x' for field B`
+	C float32 `bitfield:"4"`           // want `bitfield annotation cannot be applied to field C`
+	D uint8   `sizeOf:"Missing"`       // want `cannot locate field 'Missing' referenced by D`
+	E uint8   `countOf:"A"`            // want `field 'A' referenced by E must be of type slice or array`
+	F uint16  `bitfield:"4" align:"2"` // want `align cannot be applied to bitfield F`
+	g uint8   `bitfield:"8"`           // want `field g is unexported and cannot be decoded`
+	H uint8   `bitfield:"3"`
+	I []uint8
+	N uint8   `countOf:"I"`     // want `field N must precede the slice I it describes`
+	O uint8   `countOf:"$rest"` // want `remainder of the structure can only be given by sizeOf, not O`
+	P uint8   `greedy:""`       // want `greedy annotation cannot be applied to field P of type uint8`
+	Q []uint8 `greedy:""`       // want `greedy slice Q must be the last field`
+	R uint8
+	S uint8 `terminator:"0"` // want `terminator annotation cannot be applied to field S of type uint8`
+}
+
+// Bitfields continue into nested structures
+type Spanning struct {
+	Flags uint8 `bitfield:"4"`
+	Rest  Half
+}
+
+type Half struct {
+	Low  uint8 `bitfield:"4"`
+	Next uint8
+}
+
+type Odd struct { // want `structure leaves 7 left-over bits`
+	Flags uint8 `bitfield:"3"`
+	Rest  Half
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type ipv4Header struct {
+	_           struct{} `bitorder:"msb"`
+	Version     uint8    `bitfield:"4"`
+	IHL         uint8    `bitfield:"4"`
+	DSCP        uint8    `bitfield:"6"`
+	ECN         uint8    `bitfield:"2"`
+	TotalLength uint16   `big:""`
+	ID          uint16   `big:""`
+	Flags       uint8    `bitfield:"3"`
+	FragOffset  uint16   `bitfield:"13" big:""`
+	TTL         uint8
+	Protocol    uint8
+	Checksum    uint16 `big:""`
+	Src         [4]uint8
+	Dst         [4]uint8
+}
+
+type tcpHeader struct {
+	SrcPort    uint16 `big:""`
+	DstPort    uint16 `big:""`
+	Seq        uint32 `big:""`
+	Ack        uint32 `big:""`
+	DataOffset uint8  `bitfield:"4"`
+	Reserved   uint8  `bitfield:"3,reserved"`
+	NS         bool
+	CWR        bool
+	ECE        bool
+	URG        bool
+	ACK        bool
+	PSH        bool
+	RST        bool
+	SYN        bool
+	FIN        bool
+	Window     uint16 `big:""`
+	Checksum   uint16 `big:""`
+	Urgent     uint16 `big:""`
+}
+
+// The tcpHeader structure is declared without a bit order; it is
+// inherited from the field holding it.
+type tcpPacket struct {
+	IP  ipv4Header
+	TCP tcpHeader `bitorder:"msb"`
+}
+
+func TestBitOrderCaptures(t *testing.T) {
+	tests := []struct {
+		name    string
+		capture []byte
+		packet  tcpPacket
+	}{
+		{
+			name: "SYN",
+			capture: []byte{
+				0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00, 0x40, 0x06, 0xb1, 0xe6, 0xac, 0x10, 0x00, 0x0a, 0xac, 0x10, 0x00, 0x0c,
+				0xd3, 0x1c, 0x00, 0x50, 0x5e, 0x0c, 0x3e, 0x0b, 0x00, 0x00, 0x00, 0x00, 0xa0, 0x02, 0xfa, 0xf0, 0x3d, 0x6d, 0x00, 0x00,
+			},
+			packet: tcpPacket{
+				IP: ipv4Header{
+					Version: 4, IHL: 5, TotalLength: 60, ID: 0x1c46, Flags: 2, TTL: 64, Protocol: 6, Checksum: 0xb1e6,
+					Src: [4]uint8{172, 16, 0, 10}, Dst: [4]uint8{172, 16, 0, 12},
+				},
+				TCP: tcpHeader{
+					SrcPort: 54044, DstPort: 80, Seq: 0x5e0c3e0b, DataOffset: 10, SYN: true, Window: 64240, Checksum: 0x3d6d,
+				},
+			},
+		},
+		{
+			name: "Fragment",
+			capture: []byte{
+				0x45, 0xb8, 0x05, 0xdc, 0x1c, 0x47, 0x20, 0xb9, 0x3f, 0x06, 0x00, 0x00, 0xac, 0x10, 0x00, 0x0c, 0xac, 0x10, 0x00, 0x0a,
+				0x00, 0x50, 0xd3, 0x1c, 0x9a, 0x3b, 0x11, 0x20, 0x5e, 0x0c, 0x3e, 0x0c, 0x50, 0x18, 0x01, 0xf5, 0x9c, 0x2e, 0x00, 0x00,
+			},
+			packet: tcpPacket{
+				IP: ipv4Header{
+					Version: 4, IHL: 5, DSCP: 46, TotalLength: 1500, ID: 0x1c47, Flags: 1, FragOffset: 185, TTL: 63, Protocol: 6,
+					Src: [4]uint8{172, 16, 0, 12}, Dst: [4]uint8{172, 16, 0, 10},
+				},
+				TCP: tcpHeader{
+					SrcPort: 80, DstPort: 54044, Seq: 0x9a3b1120, Ack: 0x5e0c3e0c, DataOffset: 5, ACK: true, PSH: true,
+					Window: 501, Checksum: 0x9c2e,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packAndTest(t, test.packet, func(t *testing.T, tw *testWriter) {
+				if actual := tw.getBytes(0, tw.getSize()-1); !bytes.Equal(actual, test.capture) {
+					t.Errorf("Invalid encoding:\nExpected: %x\nActual:   %x", test.capture, actual)
+				}
+			})
+
+			unpackAndTest(t, new(tcpPacket), newReader(test.capture), func(t *testing.T, i interface{}) {
+				if p := i.(*tcpPacket); !reflect.DeepEqual(*p, test.packet) {
+					t.Errorf("Invalid decoding:\nExpected: %+v\nActual:   %+v", test.packet, *p)
+				}
+			})
+
+			sz, err := Size(test.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sz != uint64(len(test.capture)) {
+				t.Errorf("Invalid size: Expected: %d Actual: %d", len(test.capture), sz)
+			}
+		})
+	}
+}
+
+func TestBitOrderField(t *testing.T) {
+	type s struct {
+		A uint8  `bitfield:"3" bitorder:"msb"`
+		B uint8  `bitfield:"5" bitorder:"msb"`
+		C uint8  `bitfield:"3"`
+		D uint8  `bitfield:"5"`
+		E uint16 `bitorder:"msb"`
+	}
+
+	expected := s{A: 5, B: 3, C: 5, D: 3, E: 0x1234}
+	encoded := []byte{0xa3, 0x1d, 0x34, 0x12}
+
+	packAndTest(t, expected, func(t *testing.T, tw *testWriter) {
+		if actual := tw.getBytes(0, tw.getSize()-1); !bytes.Equal(actual, encoded) {
+			t.Errorf("Invalid encoding:\nExpected: %x\nActual:   %x", encoded, actual)
+		}
+	})
+
+	unpackAndTest(t, new(s), newReader(encoded), func(t *testing.T, i interface{}) {
+		if actual := i.(*s); *actual != expected {
+			t.Errorf("Invalid decoding:\nExpected: %+v\nActual:   %+v", expected, *actual)
+		}
+	})
+}
+
+func TestBitOrderMixed(t *testing.T) {
+	type s struct {
+		A uint8 `bitfield:"4"`
+		B uint8 `bitfield:"4" bitorder:"msb"`
+	}
+
+	if err := Encode(&testWriter{}, s{}); err == nil {
+		t.Error("Expected error mixing bit orders within a byte")
+	}
+
+	if err := Decode(newReader([]byte{0}), new(s)); err == nil {
+		t.Error("Expected error mixing bit orders within a byte")
+	}
+}
+
+func TestBitOrderTaggingError(t *testing.T) {
+	type s struct {
+		A uint8 `bitorder:"middle"`
+	}
+
+	if _, err := Size(s{}); err == nil {
+		t.Error("Expected tagging error for unknown bit order")
+	}
+}
@@ -47,7 +47,7 @@ WriteByte implements the io.Writer requirement for the Buffer
 */
 func (buf *Buffer) WriteByte(b byte) error {
 	if buf.offset >= len(buf.bytes) {
-		return fmt.Errorf("Write buffer overrun")
+		return fmt.Errorf("Write buffer overrun: %w", ErrShortBuffer)
 	}
 
 	buf.bytes[buf.offset] = b
@@ -61,7 +61,7 @@ ReadByte implements the io.Reader requirement of the Buffer
 */
 func (buf *Buffer) ReadByte() (byte, error) {
 	if buf.offset >= len(buf.bytes) {
-		return 0, fmt.Errorf("Read buffer overrun")
+		return 0, fmt.Errorf("Read buffer overrun: %w", ErrShortBuffer)
 	}
 
 	b := buf.bytes[buf.offset]
@@ -0,0 +1,318 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A ChecksumFunc computes the checksum of data. The result is truncated to
+// the width of the checksum field.
+type ChecksumFunc func(data []byte) uint64
+
+var checksums = map[string]ChecksumFunc{
+	"crc8":     crc8,
+	"crc16":    crc16,
+	"crc32":    func(data []byte) uint64 { return uint64(crc32.ChecksumIEEE(data)) },
+	"crc32c":   func(data []byte) uint64 { return uint64(crc32.Checksum(data, castagnoli)) },
+	"sum8":     sum8,
+	"twos":     func(data []byte) uint64 { return -sum8(data) & 0xFF },
+	"internet": internet,
+}
+
+var (
+	checksumsLock sync.RWMutex
+	castagnoli    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+/*
+RegisterChecksum registers the checksum algorithm fn under name for use in
+checksum annotations, replacing any algorithm of the same name. Names are
+not case sensitive. Algorithms must be registered before the first use of
+any structure referencing them.
+*/
+func RegisterChecksum(name string, fn ChecksumFunc) {
+	checksumsLock.Lock()
+	defer checksumsLock.Unlock()
+
+	checksums[strings.ToLower(name)] = fn
+}
+
+func lookupChecksum(name string) (ChecksumFunc, bool) {
+	checksumsLock.RLock()
+	defer checksumsLock.RUnlock()
+
+	fn, ok := checksums[strings.ToLower(name)]
+	return fn, ok
+}
+
+// crc8 is CRC-8/SMBUS; polynomial 0x07, zero initial value.
+func crc8(data []byte) uint64 {
+	crc := uint8(0)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return uint64(crc)
+}
+
+// crc16 is CRC-16/ARC; polynomial 0x8005 reflected, zero initial value.
+func crc16(data []byte) uint64 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return uint64(crc)
+}
+
+func sum8(data []byte) uint64 {
+	sum := uint64(0)
+	for _, b := range data {
+		sum += uint64(b)
+	}
+	return sum & 0xFF
+}
+
+// internet is the ones' complement of the ones' complement sum of the data
+// as big-endian 16-bit words, as used by IPv4, TCP and UDP (RFC 1071).
+func internet(data []byte) uint64 {
+	sum := uint64(0)
+	for i := 0; i < len(data); i += 2 {
+		word := uint64(data[i]) << 8
+		if i+1 < len(data) {
+			word |= uint64(data[i+1])
+		}
+		sum += word
+	}
+
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+
+	return ^sum & 0xFFFF
+}
+
+// checksum describes a field annotated with
+// `checksum:"algorithm[,from=Field][,to=Field]"`.
+type checksum struct {
+	fn       ChecksumFunc
+	from, to string // Fields starting and ending the range; empty for the whole structure
+}
+
+func parseChecksum(val string) (checksum, bool) {
+	opts := strings.Split(val, ",")
+
+	fn, ok := lookupChecksum(strings.TrimSpace(opts[0]))
+	if !ok {
+		return checksum{}, false
+	}
+
+	c := checksum{fn: fn}
+	for _, opt := range opts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 || len(kv[1]) == 0 {
+			return checksum{}, false
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "from":
+			c.from = kv[1]
+		case "to":
+			c.to = kv[1]
+		default:
+			return checksum{}, false
+		}
+	}
+
+	return c, true
+}
+
+// A span is the range of bytes [start, end) a field was transcoded to,
+// relative to the start of the enclosing structure.
+type span struct {
+	start, end uint64
+}
+
+// computeChecksum returns the checksum of field k of plan within data, the
+// encoding of the structure with the field and all later checksum fields
+// holding zero.
+func (p *structPlan) computeChecksum(k int, data []byte, spans []span) (uint64, error) {
+	c := &p.fields[k].tags.checksum
+
+	from, to := 0, len(p.fields)-1
+	if len(c.from) != 0 {
+		from, _ = p.fieldByName(c.from)
+	}
+	if len(c.to) != 0 {
+		to, _ = p.fieldByName(c.to)
+	}
+
+	start, end := spans[from].start, spans[to].end
+	if start == unaligned || end == unaligned || end > uint64(len(data)) {
+		return 0, fmt.Errorf("Checksum range of field %s does not start and end on a byte boundary: %w", p.fields[k].name, ErrTag)
+	}
+
+	v := c.fn(data[start:end])
+	if nbits := p.fields[k].tags.bitfield.nbits; nbits < 64 {
+		v &= 1<<nbits - 1
+	}
+
+	return v, nil
+}
+
+// unaligned marks a span boundary not on a byte boundary.
+const unaligned = ^uint64(0)
+
+// checksummed encodes the structure val holding checksum fields. Each
+// checksum is computed, in field order, over a trial encoding of a copy of
+// the structure in which it and any later checksum fields hold zero.
+func (e *encoder) checksummed(t *transcoder, val reflect.Value, plan *structPlan, order bitOrder) error {
+	if e.bitOffset != 0 {
+		return fmt.Errorf("Structure with checksum must start on a byte boundary: %w", ErrTag)
+	}
+
+	cp := reflect.New(val.Type()).Elem()
+	cp.Set(val)
+
+	for _, k := range plan.checksums {
+		cp.Field(plan.fields[k].index).SetUint(0)
+	}
+
+	for _, k := range plan.checksums {
+		var buf bytes.Buffer
+
+		trial := encoder{writer: &buf, byteOffset: e.byteOffset}
+		trial.transcoder = t.fork(&trial)
+
+		spans := make([]span, len(plan.fields))
+		if err := trial.transcoder.transcodeStruct(cp, plan, order, spans); err != nil {
+			return err
+		}
+
+		for i := range spans {
+			spans[i] = spans[i].relative(e.byteOffset)
+		}
+
+		v, err := plan.computeChecksum(k, buf.Bytes(), spans)
+		if err != nil {
+			return err
+		}
+
+		cp.Field(plan.fields[k].index).SetUint(v)
+	}
+
+	return t.transcodeStruct(cp, plan, order, nil)
+}
+
+// checksummed decodes the structure val holding checksum fields, verifying
+// each against the bytes read.
+func (d *decoder) checksummed(t *transcoder, val reflect.Value, plan *structPlan, order bitOrder) error {
+	if d.bitOffset != 0 {
+		return fmt.Errorf("Structure with checksum must start on a byte boundary: %w", ErrTag)
+	}
+
+	base := d.byteOffset
+	start := len(d.recorded)
+
+	recording := d.recording
+	d.recording = true
+	defer func() {
+		d.recording = recording
+		if !recording {
+			d.recorded = d.recorded[:0]
+		}
+	}()
+
+	spans := make([]span, len(plan.fields))
+	if err := t.transcodeStruct(val, plan, order, spans); err != nil {
+		return err
+	}
+
+	for i := range spans {
+		spans[i] = spans[i].relative(base)
+	}
+
+	data := make([]byte, len(d.recorded)-start)
+
+	for n, k := range plan.checksums {
+		copy(data, d.recorded[start:])
+
+		// As encoded, the field and any later checksum fields are zero
+		for _, z := range plan.checksums[n:] {
+			s := spans[z]
+			if s.start == unaligned || s.end == unaligned {
+				return fmt.Errorf("Checksum field %s does not start and end on a byte boundary: %w", plan.fields[z].name, ErrTag)
+			}
+			for i := s.start; i < s.end; i++ {
+				data[i] = 0
+			}
+		}
+
+		expected, err := plan.computeChecksum(k, data, spans)
+		if err != nil {
+			return err
+		}
+
+		if v := val.Field(plan.fields[k].index).Uint(); v != expected {
+			err := fmt.Errorf("Checksum %#x does not match computed %#x: %w", v, expected, ErrChecksum)
+			return fieldError(err, plan.fields[k].name, base+spans[k].start, 0)
+		}
+	}
+
+	return nil
+}
+
+func (s *sizer) checksummed(t *transcoder, val reflect.Value, plan *structPlan, order bitOrder) error {
+	return t.transcodeStruct(val, plan, order, nil)
+}
+
+// relative returns the span relative to the byte offset base, marking
+// boundaries part way through a byte as unaligned.
+func (s span) relative(base uint64) span {
+	r := span{start: s.start - base, end: s.end - base}
+	if s.start == unaligned {
+		r.start = unaligned
+	}
+	if s.end == unaligned {
+		r.end = unaligned
+	}
+	return r
+}
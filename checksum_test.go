@@ -0,0 +1,257 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func TestChecksumAlgorithms(t *testing.T) {
+	data := []byte("123456789")
+
+	tests := []struct {
+		name     string
+		expected uint64
+	}{
+		{"crc8", 0xF4},
+		{"crc16", 0xBB3D},
+		{"crc32", 0xCBF43926},
+		{"CRC32C", 0xE3069283},
+		{"sum8", 0xDD},
+		{"twos", 0x23},
+		{"internet", 0xF62A},
+	}
+
+	for _, test := range tests {
+		fn, ok := lookupChecksum(test.name)
+		if !ok {
+			t.Errorf("Checksum %s not found", test.name)
+			continue
+		}
+		if actual := fn(data); actual != test.expected {
+			t.Errorf("Invalid %s checksum: Expected: %#x Actual: %#x", test.name, test.expected, actual)
+		}
+	}
+}
+
+type checksumIPv4 struct {
+	VersionIHL  uint8
+	TOS         uint8
+	TotalLength uint16 `big:""`
+	ID          uint16 `big:""`
+	FlagsFrag   uint16 `big:""`
+	TTL         uint8
+	Protocol    uint8
+	Checksum    uint16 `big:"" checksum:"internet"`
+	Source      [4]byte
+	Destination [4]byte
+}
+
+var ipv4Bytes = []byte{
+	0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11,
+	0xB8, 0x61,
+	0xC0, 0xA8, 0x00, 0x01, 0xC0, 0xA8, 0x00, 0xC7,
+}
+
+func TestChecksumInternet(t *testing.T) {
+	h := checksumIPv4{
+		VersionIHL:  0x45,
+		TotalLength: 0x73,
+		FlagsFrag:   0x4000,
+		TTL:         0x40,
+		Protocol:    0x11,
+		Source:      [4]byte{192, 168, 0, 1},
+		Destination: [4]byte{192, 168, 0, 199},
+	}
+
+	b, err := EncodeByteBuffer(h)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	if !bytes.Equal(b, ipv4Bytes) {
+		t.Errorf("Invalid encoding:\nExpected: %#v\nActual:   %#v", ipv4Bytes, b)
+	}
+
+	if h.Checksum != 0 {
+		t.Errorf("Encode modified the structure")
+	}
+
+	var d checksumIPv4
+	if err := Decode(bytes.NewReader(ipv4Bytes), &d); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if d.Checksum != 0xB861 {
+		t.Errorf("Invalid checksum: Expected: %#x Actual: %#x", 0xB861, d.Checksum)
+	}
+
+	corrupt := append([]byte{}, ipv4Bytes...)
+	corrupt[19]++
+
+	err = Decode(bytes.NewReader(corrupt), &d)
+
+	var fe *FieldError
+	if !errors.Is(err, ErrChecksum) || !errors.As(err, &fe) || fe.Path != "Checksum" || fe.Offset != 10 {
+		t.Errorf("Expected checksum error: Actual: %v", err)
+	}
+}
+
+type checksumIPMI struct {
+	RsAddr    uint8
+	NetFn     uint8
+	Checksum1 uint8 `checksum:"twos,from=RsAddr,to=NetFn"`
+	RqAddr    uint8
+	Seq       uint8
+	Cmd       uint8
+	Data      [2]byte
+	Checksum2 uint8 `checksum:"twos,from=RqAddr,to=Data"`
+}
+
+func TestChecksumRange(t *testing.T) {
+	m := checksumIPMI{RsAddr: 0x20, NetFn: 0x18, RqAddr: 0x81, Seq: 0x04, Cmd: 0x01, Data: [2]byte{0x10, 0x20}}
+
+	b, err := EncodeByteBuffer(m)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	if sum8(b[0:3]) != 0 || sum8(b[3:]) != 0 {
+		t.Errorf("Invalid checksums: %#v", b)
+	}
+
+	var d checksumIPMI
+	if err := Decode(bytes.NewReader(b), &d); err != nil {
+		t.Errorf("Decode failed: %v", err)
+	}
+
+	b[1]++
+	if err := Decode(bytes.NewReader(b), &d); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected checksum error: Actual: %v", err)
+	}
+}
+
+type checksumGPT struct {
+	Signature  [8]byte `const:"'EFI PART'"`
+	Revision   uint32
+	HeaderSize uint32
+	CRC        uint32 `checksum:"crc32"`
+	Reserved   uint32
+	CurrentLBA uint64
+}
+
+func TestChecksumWhole(t *testing.T) {
+	h := checksumGPT{Revision: 0x10000, HeaderSize: 32, CurrentLBA: 1}
+
+	b, err := EncodeByteBuffer(h)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	zeroed := append([]byte{}, b...)
+	copy(zeroed[16:20], []byte{0, 0, 0, 0})
+
+	if crc := binary.LittleEndian.Uint32(b[16:20]); crc != crc32.ChecksumIEEE(zeroed) {
+		t.Errorf("Invalid CRC: Expected: %#x Actual: %#x", crc32.ChecksumIEEE(zeroed), crc)
+	}
+
+	// Nested at an offset within a stream
+	type outer struct {
+		Prefix uint16
+		Header checksumGPT
+	}
+
+	s := append([]byte{0xAA, 0xBB}, b...)
+
+	dec := NewDecoder(bytes.NewReader(append(s, s...)))
+	for i := 0; i < 2; i++ {
+		var o outer
+		if err := dec.Decode(&o); err != nil || o.Header.CurrentLBA != 1 {
+			t.Errorf("Decode failed: %v", err)
+		}
+	}
+
+	s[len(s)-1]++
+
+	var fe *FieldError
+	err = Decode(bytes.NewReader(s), new(outer))
+	if !errors.As(err, &fe) || fe.Path != "Header.CRC" || fe.Offset != 18 || !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected checksum error: Actual: %v", err)
+	}
+}
+
+func TestChecksumRegister(t *testing.T) {
+	RegisterChecksum("xor8", func(data []byte) uint64 {
+		x := uint8(0)
+		for _, b := range data {
+			x ^= b
+		}
+		return uint64(x)
+	})
+
+	s := struct {
+		A   uint8
+		B   uint8
+		XOR uint8 `checksum:"xor8,to=B"`
+	}{A: 0x0F, B: 0x3C}
+
+	b, err := EncodeByteBuffer(s)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	if b[2] != 0x33 {
+		t.Errorf("Invalid checksum: Expected: %#x Actual: %#x", 0x33, b[2])
+	}
+}
+
+func TestChecksumTaggingError(t *testing.T) {
+	tests := []interface{}{
+		struct {
+			A uint8 `checksum:"unknown"`
+		}{},
+		struct {
+			A int8 `checksum:"sum8"`
+		}{},
+		struct {
+			A uint8 `checksum:"sum8,from=Missing"`
+		}{},
+		struct {
+			A uint8
+			B uint8 `checksum:"sum8,from=B,to=A"`
+		}{},
+		struct {
+			A uint8 `checksum:"sum8,length=4"`
+		}{},
+	}
+
+	for _, test := range tests {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,903 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/HewlettPackard/structex/internal/tag"
+)
+
+const structexPath = "github.com/HewlettPackard/structex"
+
+type endian int
+
+const (
+	undefined endian = iota
+	little
+	big
+)
+
+const (
+	none = iota
+	sizeOf
+	countOf
+)
+
+// fieldTags holds the annotations of a single field, interpreted exactly as
+// the structex runtime interprets them.
+type fieldTags struct {
+	endian   endian
+	nbits    uint64
+	reserved bool
+	layout   int
+	target   string
+	relative bool
+	align    uint64
+	truncate bool
+	tag      reflect.StructTag
+}
+
+type field struct {
+	name string
+	typ  types.Type
+	tags fieldTags
+}
+
+type generator struct {
+	pkg     *pkg
+	buf     bytes.Buffer
+	imports map[string]bool
+	queue   []*types.Named
+	queued  map[*types.Named]bool
+	names   []string
+}
+
+func newGenerator(p *pkg) *generator {
+	return &generator{
+		pkg:     p,
+		imports: map[string]bool{structexPath: true},
+		queued:  make(map[*types.Named]bool),
+	}
+}
+
+func (g *generator) generate(names []string) error {
+	for _, name := range names {
+		obj := g.pkg.types.Scope().Lookup(strings.TrimSpace(name))
+		if obj == nil {
+			return fmt.Errorf("type %s not found in package %s", name, g.pkg.name)
+		}
+
+		named, ok := obj.Type().(*types.Named)
+		if !ok {
+			return fmt.Errorf("%s is not a named type", name)
+		}
+		if _, ok := named.Underlying().(*types.Struct); !ok {
+			return fmt.Errorf("%s is not a structure type", name)
+		}
+
+		g.enqueue(named)
+	}
+
+	for i := 0; i < len(g.queue); i++ {
+		if err := g.genType(g.queue[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) enqueue(named *types.Named) {
+	if !g.queued[named] {
+		g.queued[named] = true
+		g.queue = append(g.queue, named)
+	}
+}
+
+func (g *generator) qualifier(p *types.Package) string {
+	if p == g.pkg.types {
+		return ""
+	}
+	g.imports[p.Path()] = true
+	return p.Name()
+}
+
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+// source returns the unformatted generated code.
+func (g *generator) source() []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "%s\n\n", generatedHeader)
+	fmt.Fprintf(&b, "package %s\n\n", g.pkg.name)
+	// Standard library imports first, as goimports would group them.
+	fmt.Fprintf(&b, "import (\n")
+	paths := g.sortedImports()
+	for _, path := range paths {
+		if !strings.Contains(path, ".") {
+			fmt.Fprintf(&b, "\t%q\n", path)
+		}
+	}
+	for _, path := range paths {
+		if strings.Contains(path, ".") {
+			fmt.Fprintf(&b, "\n\t%q\n", path)
+		}
+	}
+	fmt.Fprintf(&b, ")\n")
+
+	b.Write(g.buf.Bytes())
+
+	return b.Bytes()
+}
+
+func (g *generator) sortedImports() []string {
+	paths := []string{}
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// testSource returns the unformatted cross-check test.
+func (g *generator) testSource() []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "%s\n\n", generatedHeader)
+	fmt.Fprintf(&b, "package %s\n\n", g.pkg.name)
+	fmt.Fprintf(&b, "import (\n\t\"testing\"\n\n\t%q\n)\n", structexPath)
+
+	// Every other generated type is reflected in the reference encoding,
+	// so its generated code is also checked wherever it is nested.
+	for _, name := range g.names {
+		args := []string{fmt.Sprintf("new(%s)", name)}
+		for _, other := range g.names {
+			if other != name {
+				args = append(args, fmt.Sprintf("new(%s)", other))
+			}
+		}
+
+		fmt.Fprintf(&b, "\nfunc TestStructex%s(t *testing.T) {\n", name)
+		fmt.Fprintf(&b, "\tif err := structex.CheckGenerated(%s); err != nil {\n", strings.Join(args, ", "))
+		fmt.Fprintf(&b, "\t\tt.Error(err)\n")
+		fmt.Fprintf(&b, "\t}\n")
+		fmt.Fprintf(&b, "}\n")
+	}
+
+	return b.Bytes()
+}
+
+func (g *generator) genType(named *types.Named) error {
+	name := named.Obj().Name()
+	st := named.Underlying().(*types.Struct)
+
+	fields, err := g.fields(name, st)
+	if err != nil {
+		return err
+	}
+
+	var enc, dec bytes.Buffer
+	layouts := []string{}
+
+	for _, f := range fields {
+		if err := g.genField(&enc, &dec, name, f, fields); err != nil {
+			return fmt.Errorf("%s.%s: %v", name, f.name, err)
+		}
+		if f.tags.layout != none && isSliceTarget(f, fields) {
+			layouts = append(layouts, layoutVar(f.tags.target))
+		}
+	}
+
+	g.names = append(g.names, name)
+
+	fmt.Fprintf(&g.buf, "\n// MarshalStructex implements structex.Marshaler.\n")
+	fmt.Fprintf(&g.buf, "func (s %s) MarshalStructex(w structex.BitWriter, f structex.Field) error {\n", name)
+	g.buf.Write(enc.Bytes())
+	fmt.Fprintf(&g.buf, "\treturn nil\n}\n")
+
+	fmt.Fprintf(&g.buf, "\n// UnmarshalStructex implements structex.Unmarshaler.\n")
+	fmt.Fprintf(&g.buf, "func (s *%s) UnmarshalStructex(r structex.BitReader, f structex.Field) error {\n", name)
+	if len(layouts) != 0 {
+		fmt.Fprintf(&g.buf, "\tvar %s uint64\n\n", strings.Join(layouts, ", "))
+	}
+	g.buf.Write(dec.Bytes())
+	fmt.Fprintf(&g.buf, "\treturn nil\n}\n")
+
+	return nil
+}
+
+func layoutVar(target string) string {
+	return "layout" + target
+}
+
+// isSliceTarget reports if the field referenced by the layout field f is a
+// slice, allocated when decoding from the value of f. Arrays are not.
+func isSliceTarget(f field, fields []field) bool {
+	for _, t := range fields {
+		if t.name == f.tags.target {
+			_, ok := t.typ.Underlying().(*types.Slice)
+			return ok
+		}
+	}
+	return false
+}
+
+// elemSize returns the size in bytes of the elements of the array or slice
+// field f. Elements annotated with a width are sized by it, as the runtime.
+func (g *generator) elemSize(f field) (uint64, error) {
+	elem := f.typ
+	switch t := elem.Underlying().(type) {
+	case *types.Array:
+		elem = t.Elem()
+	case *types.Slice:
+		elem = t.Elem()
+	}
+
+	if nbits := f.tags.nbits; nbits != 0 && nbits%8 == 0 && !g.isCustom(elem) {
+		return nbits / 8, nil
+	}
+	return staticSize(elem)
+}
+
+// genCount writes the start of the loop encoding the elements of the array
+// or slice field f. A value already set in the layout field ref limits the
+// elements encoded, as the runtime does.
+func (g *generator) genCount(enc *bytes.Buffer, expr string, f field, ref *field) error {
+	if ref == nil {
+		fmt.Fprintf(enc, "\t{\n\t\tfor i := range %s {\n", expr)
+		return nil
+	}
+
+	fmt.Fprintf(enc, "\t{\n\t\tn := len(%s)\n", expr)
+	fmt.Fprintf(enc, "\t\tif v := uint64(s.%s); v != 0 {\n", ref.name)
+	if ref.tags.layout == sizeOf {
+		sz, err := g.elemSize(f)
+		if err != nil {
+			return err
+		}
+		if sz != 0 {
+			fmt.Fprintf(enc, "\t\t\tv /= %d\n", sz)
+		}
+	}
+	fmt.Fprintf(enc, "\t\t\tif v < uint64(n) {\n\t\t\t\tn = int(v)\n\t\t\t}\n")
+	fmt.Fprintf(enc, "\t\t}\n")
+	fmt.Fprintf(enc, "\t\tfor i := 0; i < n; i++ {\n")
+
+	return nil
+}
+
+// fields parses and checks the annotations of every field of a structure.
+func (g *generator) fields(name string, st *types.Struct) ([]field, error) {
+	fields := make([]field, st.NumFields())
+	index := make(map[string]int)
+
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+
+		if !v.Exported() {
+			return nil, fmt.Errorf("%s.%s: unexported fields cannot be decoded", name, v.Name())
+		}
+
+		tags, err := parseTags(reflect.StructTag(st.Tag(i)), v.Type())
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", name, v.Name(), err)
+		}
+
+		fields[i] = field{name: v.Name(), typ: v.Type(), tags: tags}
+		index[v.Name()] = i
+	}
+
+	for i, f := range fields {
+		if f.tags.layout == none {
+			continue
+		}
+
+		j, ok := index[f.tags.target]
+		if !ok {
+			return nil, fmt.Errorf("%s.%s: layout of field '%s' outside of %s is not supported", name, f.name, f.tags.target, name)
+		}
+		if j < i {
+			return nil, fmt.Errorf("%s.%s: layout must precede field '%s'", name, f.name, f.tags.target)
+		}
+
+		switch fields[j].typ.Underlying().(type) {
+		case *types.Array, *types.Slice:
+		case *types.Struct, *types.Pointer, *types.Interface:
+			if f.tags.layout == sizeOf {
+				return nil, fmt.Errorf("%s.%s: sizeOf of a structure not supported by structexgen", name, f.name)
+			}
+			return nil, fmt.Errorf("%s.%s: referenced layout must be of type slice or array", name, f.name)
+		default:
+			return nil, fmt.Errorf("%s.%s: referenced layout must be of type slice or array", name, f.name)
+		}
+
+		if b, ok := basicOf(f.typ); !ok || b.boolean || b.signed || b.float {
+			return nil, fmt.Errorf("%s.%s: layout must be an unsigned integer", name, f.name)
+		}
+	}
+
+	return fields, nil
+}
+
+func (g *generator) genField(enc, dec *bytes.Buffer, name string, f field, fields []field) error {
+	expr := "s." + f.name
+
+	if f.tags.align != 0 {
+		fmt.Fprintf(enc, "\tif err := w.Align(%d); err != nil {\n\t\treturn err\n\t}\n", f.tags.align)
+		fmt.Fprintf(dec, "\tif err := r.Align(%d); err != nil {\n\t\treturn err\n\t}\n", f.tags.align)
+	}
+
+	// The field describing this array or slice, if any.
+	var ref *field
+	for i := range fields {
+		if fields[i].tags.layout != none && fields[i].tags.target == f.name {
+			ref = &fields[i]
+		}
+	}
+
+	if g.isCustom(f.typ) {
+		return g.genCall(enc, dec, expr, f.typ, f.tags, false)
+	}
+
+	switch t := f.typ.Underlying().(type) {
+	case *types.Struct:
+		return g.genCall(enc, dec, expr, f.typ, f.tags, false)
+
+	case *types.Array:
+		if err := g.genCount(enc, expr, f, ref); err != nil {
+			return err
+		}
+		if err := g.genElem(enc, nil, expr+"[i]", t.Elem(), f.tags, false); err != nil {
+			return err
+		}
+		fmt.Fprintf(enc, "\t\t}\n\t}\n")
+
+		fmt.Fprintf(dec, "\tfor i := range %s {\n", expr)
+		if err := g.genElem(nil, dec, expr+"[i]", t.Elem(), f.tags, false); err != nil {
+			return err
+		}
+		fmt.Fprintf(dec, "\t}\n")
+
+	case *types.Slice:
+		if err := g.genCount(enc, expr, f, ref); err != nil {
+			return err
+		}
+		if err := g.genElem(enc, nil, expr+"[i]", t.Elem(), f.tags, true); err != nil {
+			return err
+		}
+		fmt.Fprintf(enc, "\t\t}\n\t}\n")
+
+		if ref != nil {
+			length := layoutVar(f.name)
+			if ref.tags.layout == sizeOf {
+				sz, err := g.elemSize(f)
+				if err != nil {
+					return err
+				}
+				g.imports["fmt"] = true
+				fmt.Fprintf(dec, "\tif %s%%%d != 0 {\n", length, sz)
+				fmt.Fprintf(dec, "\t\treturn fmt.Errorf(\"Slice with size %%d of slice is a non-multiple of structure size %%d\", %s, %d)\n", length, sz)
+				fmt.Fprintf(dec, "\t}\n")
+				length = fmt.Sprintf("%s/%d", length, sz)
+			}
+			fmt.Fprintf(dec, "\tif err := r.SliceLen(%s); err != nil {\n\t\treturn err\n\t}\n", length)
+			fmt.Fprintf(dec, "\t%s = make(%s, %s)\n", expr, g.typeString(f.typ), length)
+		}
+		fmt.Fprintf(dec, "\tfor i := range %s {\n", expr)
+		if err := g.genElem(nil, dec, expr+"[i]", t.Elem(), f.tags, true); err != nil {
+			return err
+		}
+		fmt.Fprintf(dec, "\t}\n")
+
+	default:
+		b, ok := basicOf(f.typ)
+		if !ok {
+			return fmt.Errorf("field type %s unsupported", f.typ.String())
+		}
+
+		if f.tags.layout != none {
+			return g.genLayout(enc, dec, expr, f, b, fields)
+		}
+
+		g.genEncodeValue(enc, "\t", expr, b, f.tags)
+		g.genDecodeValue(dec, "\t", expr, f.typ, b, f.tags, false, "")
+	}
+
+	return nil
+}
+
+// genElem writes the encoding or decoding of a single array or slice element.
+// As with the reflective decoder, truncation applies to every slice element
+// but only to array elements of integer types.
+func (g *generator) genElem(enc, dec *bytes.Buffer, expr string, typ types.Type, tags fieldTags, slice bool) error {
+	if _, ok := typ.Underlying().(*types.Struct); ok || g.isCustom(typ) {
+		return g.genCall(enc, dec, expr, typ, tags, slice && tags.truncate)
+	}
+
+	b, ok := basicOf(typ)
+	if !ok {
+		return fmt.Errorf("element type %s unsupported", typ.String())
+	}
+
+	if tags.nbits == 0 && b.boolean {
+		return fmt.Errorf("bool elements require a bitfield annotation")
+	}
+	if tags.nbits > b.bits {
+		return fmt.Errorf("bitfield of %d bits exceeds element size of %d bits", tags.nbits, b.bits)
+	}
+
+	if enc != nil {
+		g.genEncodeValue(enc, "\t\t\t", expr, b, tags)
+	}
+	if dec != nil {
+		g.genDecodeValue(dec, "\t\t", expr, typ, b, tags, tags.truncate, "")
+	}
+
+	return nil
+}
+
+// genCall writes calls to the Marshaler and Unmarshaler of a nested value.
+// Structures of this package without their own are queued for generation.
+func (g *generator) genCall(enc, dec *bytes.Buffer, expr string, typ types.Type, tags fieldTags, truncate bool) error {
+	if !g.isCustom(typ) {
+		named, ok := typ.(*types.Named)
+		if !ok || named.Obj().Pkg() != g.pkg.types {
+			return fmt.Errorf("nested structure %s must be a named type of package %s", typ.String(), g.pkg.name)
+		}
+		g.enqueue(named)
+	}
+
+	if enc != nil {
+		fmt.Fprintf(enc, "if err := %s.MarshalStructex(w, %s); err != nil {\nreturn err\n}\n", expr, g.fieldLiteral(tags, "w"))
+	}
+	if dec != nil {
+		fmt.Fprintf(dec, "if err := %s.UnmarshalStructex(r, %s); err != nil {\n", expr, g.fieldLiteral(tags, "r"))
+		if truncate {
+			g.imports["io"] = true
+			fmt.Fprintf(dec, "if err == io.EOF {\nbreak\n}\n")
+		}
+		fmt.Fprintf(dec, "return err\n}\n")
+	}
+
+	return nil
+}
+
+// isCustom reports if typ has its own, hand-written, Marshaler and
+// Unmarshaler. Previously generated files are never loaded so any methods
+// found are not ours.
+func (g *generator) isCustom(typ types.Type) bool {
+	ms := types.NewMethodSet(types.NewPointer(typ))
+	return ms.Lookup(g.pkg.types, "MarshalStructex") != nil || ms.Lookup(g.pkg.types, "UnmarshalStructex") != nil
+}
+
+// fieldLiteral returns the structex.Field passed to nested values, matching
+// the Field the reflective path would pass.
+func (g *generator) fieldLiteral(tags fieldTags, stream string) string {
+	members := []string{}
+
+	switch tags.endian {
+	case big:
+		members = append(members, "BigEndian: true")
+	case undefined:
+		members = append(members, "BigEndian: "+stream+".BigEndian()")
+	}
+	if tags.nbits != 0 {
+		members = append(members, fmt.Sprintf("Bits: %d", tags.nbits))
+	}
+	if tags.reserved {
+		members = append(members, "Reserved: true")
+	}
+	if tags.truncate {
+		members = append(members, "Truncate: true")
+	}
+	if len(tags.tag) != 0 {
+		members = append(members, "Tag: "+strconv.Quote(string(tags.tag)))
+	}
+
+	return "structex.Field{" + strings.Join(members, ", ") + "}"
+}
+
+func (g *generator) genLayout(enc, dec *bytes.Buffer, expr string, f field, b basic, fields []field) error {
+	target := "s." + f.tags.target
+
+	fmt.Fprintf(enc, "\t{\n\t\tv := uint64(%s)\n", expr)
+	switch f.tags.layout {
+	case sizeOf:
+		var ref field
+		for _, t := range fields {
+			if t.name == f.tags.target {
+				ref = t
+			}
+		}
+
+		sz, err := g.elemSize(ref)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(enc, "\t\tif v == 0 && len(%s) != 0 {\n", target)
+		fmt.Fprintf(enc, "\t\t\tv = uint64(len(%s)) * %d\n", target, sz)
+		if f.tags.relative {
+			fmt.Fprintf(enc, "\t\t\tv -= w.Offset()\n")
+		}
+		fmt.Fprintf(enc, "\t\t}\n")
+
+	case countOf:
+		fmt.Fprintf(enc, "\t\tif v == 0 {\n\t\t\tv = uint64(len(%s))\n\t\t}\n", target)
+	}
+	if f.tags.nbits < 64 {
+		fmt.Fprintf(enc, "\t\tvar err error\n")
+		g.genFit(enc, "\t\t", f.tags.nbits, b.signed)
+	}
+	g.genWrite(enc, "\t\t", f.tags.nbits, f.tags)
+	fmt.Fprintf(enc, "\t}\n")
+
+	layout := ""
+	if isSliceTarget(f, fields) {
+		layout = layoutVar(f.tags.target)
+	}
+	g.genDecodeValue(dec, "\t", expr, f.typ, b, f.tags, false, layout)
+
+	return nil
+}
+
+// genEncodeValue writes the encoding of an integer or boolean value.
+func (g *generator) genEncodeValue(b *bytes.Buffer, indent string, expr string, info basic, tags fieldTags) {
+	nbits := tags.nbits
+	if nbits == 0 {
+		nbits = info.bits
+	}
+
+	fmt.Fprintf(b, "%s{\n", indent)
+	if info.boolean {
+		fmt.Fprintf(b, "%s\tv := uint64(0)\n%s\tif %s {\n%s\t\tv = 1\n%s\t}\n", indent, indent, expr, indent, indent)
+	} else if info.float {
+		g.imports["math"] = true
+		fmt.Fprintf(b, "%s\tv := uint64(math.Float%dbits(float%d(%s)))\n", indent, info.bits, info.bits, expr)
+	} else {
+		fmt.Fprintf(b, "%s\tv := uint64(%s)\n", indent, expr)
+	}
+	fit := !info.boolean && !info.float && nbits < info.bits
+	if tags.reserved || fit {
+		fmt.Fprintf(b, "%s\tvar err error\n", indent)
+	}
+	if tags.reserved {
+		fmt.Fprintf(b, "%s\tif v, err = w.Reserved(v, %d); err != nil {\n%s\t\treturn err\n%s\t}\n", indent, nbits, indent, indent)
+	}
+	if fit {
+		g.genFit(b, indent+"\t", nbits, info.signed)
+	}
+	g.genWrite(b, indent+"\t", nbits, tags)
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// genDecodeValue writes the decoding of an integer or boolean value. If
+// truncate is set a short read ends the enclosing loop. If layout is set
+// the raw value is also saved to the named variable.
+func (g *generator) genDecodeValue(b *bytes.Buffer, indent string, expr string, typ types.Type, info basic, tags fieldTags, truncate bool, layout string) {
+	nbits := info.bits
+	if tags.nbits > 0 {
+		nbits = tags.nbits
+	}
+
+	fmt.Fprintf(b, "%s{\n", indent)
+	g.genRead(b, indent+"\t", nbits, tags)
+	fmt.Fprintf(b, "%s\tif err != nil {\n", indent)
+	if truncate {
+		g.imports["io"] = true
+		fmt.Fprintf(b, "%s\t\tif err == io.EOF {\n%s\t\t\tbreak\n%s\t\t}\n", indent, indent, indent)
+	}
+	fmt.Fprintf(b, "%s\t\treturn err\n%s\t}\n", indent, indent)
+	if tags.reserved {
+		name := strings.TrimPrefix(expr, "s.")
+		if i := strings.Index(name, "["); i >= 0 {
+			name = name[:i]
+		}
+		fmt.Fprintf(b, "%s\tif err := r.Reserved(%q, v, %d); err != nil {\n%s\t\treturn err\n%s\t}\n", indent, name, nbits, indent, indent)
+	}
+
+	t := g.typeString(typ)
+	switch {
+	case info.boolean:
+		fmt.Fprintf(b, "%s\t%s = v == 1\n", indent, expr)
+	case info.float:
+		g.imports["math"] = true
+		fmt.Fprintf(b, "%s\t%s = %s(math.Float%dfrombits(uint%d(v)))\n", indent, expr, t, info.bits, info.bits)
+	case info.signed && nbits < 64:
+		fmt.Fprintf(b, "%s\tif v>>%d == 1 {\n", indent, nbits-1)
+		fmt.Fprintf(b, "%s\t\t%s = %s(int64(v) - %d - 1)\n", indent, expr, t, (uint64(1)<<nbits)-1)
+		fmt.Fprintf(b, "%s\t} else {\n", indent)
+		fmt.Fprintf(b, "%s\t\t%s = %s(v)\n", indent, expr, t)
+		fmt.Fprintf(b, "%s\t}\n", indent)
+	default:
+		fmt.Fprintf(b, "%s\t%s = %s(v)\n", indent, expr, t)
+	}
+
+	if layout != "" {
+		fmt.Fprintf(b, "%s\t%s = v\n", indent, layout)
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// genWrite writes the call writing nbits of v in the byte order of the
+// field. Byte order only applies to fields wider than a byte.
+func (g *generator) genWrite(b *bytes.Buffer, indent string, nbits uint64, tags fieldTags) {
+	write := "w.WriteBits"
+	if nbits > 8 {
+		switch tags.endian {
+		case big:
+			write = "w.WriteBigEndian"
+		case undefined:
+			fmt.Fprintf(b, "%swrite := w.WriteBits\n%sif w.BigEndian() {\n%s\twrite = w.WriteBigEndian\n%s}\n", indent, indent, indent, indent)
+			write = "write"
+		}
+	}
+
+	fmt.Fprintf(b, "%sif err := %s(v, %d); err != nil {\n%s\treturn err\n%s}\n", indent, write, nbits, indent, indent)
+}
+
+// genFit writes the check that v fits a field of nbits, as the runtime
+// applies Options.Overflow. The variable err must be declared.
+func (g *generator) genFit(b *bytes.Buffer, indent string, nbits uint64, signed bool) {
+	fmt.Fprintf(b, "%sif v, err = w.Fit(v, %d, %t); err != nil {\n%s\treturn err\n%s}\n", indent, nbits, signed, indent, indent)
+}
+
+// genRead writes the call reading nbits into v in the byte order of the
+// field. See genWrite.
+func (g *generator) genRead(b *bytes.Buffer, indent string, nbits uint64, tags fieldTags) {
+	read := "r.ReadBits"
+	if nbits > 8 {
+		switch tags.endian {
+		case big:
+			read = "r.ReadBigEndian"
+		case undefined:
+			fmt.Fprintf(b, "%sread := r.ReadBits\n%sif r.BigEndian() {\n%s\tread = r.ReadBigEndian\n%s}\n", indent, indent, indent, indent)
+			read = "read"
+		}
+	}
+
+	fmt.Fprintf(b, "%sv, err := %s(%d)\n", indent, read, nbits)
+}
+
+type basic struct {
+	bits    uint64
+	signed  bool
+	boolean bool
+	float   bool
+}
+
+func basicOf(t types.Type) (basic, bool) {
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return basic{}, false
+	}
+
+	switch b.Kind() {
+	case types.Bool:
+		return basic{bits: 1, boolean: true}, true
+	case types.Int8:
+		return basic{bits: 8, signed: true}, true
+	case types.Int16:
+		return basic{bits: 16, signed: true}, true
+	case types.Int32:
+		return basic{bits: 32, signed: true}, true
+	case types.Int64:
+		return basic{bits: 64, signed: true}, true
+	case types.Uint8:
+		return basic{bits: 8}, true
+	case types.Uint16:
+		return basic{bits: 16}, true
+	case types.Uint32:
+		return basic{bits: 32}, true
+	case types.Uint64:
+		return basic{bits: 64}, true
+	case types.Float32:
+		return basic{bits: 32, float: true}, true
+	case types.Float64:
+		return basic{bits: 64, float: true}, true
+	}
+
+	return basic{}, false
+}
+
+// staticSize returns the size in bytes of an element of a sizeOf layout.
+// Only elements whose size does not depend on their contents are supported.
+func staticSize(t types.Type) (uint64, error) {
+	if b, ok := basicOf(t); ok {
+		if b.boolean {
+			return 0, fmt.Errorf("sizeOf elements of type bool unsupported")
+		}
+		return b.bits / 8, nil
+	}
+
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return 0, fmt.Errorf("sizeOf elements of type %s unsupported", t.String())
+	}
+
+	nbits := uint64(0)
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+
+		tags, err := parseTags(reflect.StructTag(st.Tag(i)), v.Type())
+		if err != nil {
+			return 0, err
+		}
+		if tags.layout != none || tags.align != 0 {
+			return 0, fmt.Errorf("sizeOf elements of type %s have a dynamic size", t.String())
+		}
+
+		switch ft := v.Type().Underlying().(type) {
+		case *types.Struct:
+			sz, err := staticSize(v.Type())
+			if err != nil {
+				return 0, err
+			}
+			nbits += sz * 8
+		case *types.Array:
+			sz, err := staticSize(ft.Elem())
+			if err != nil {
+				return 0, err
+			}
+			nbits += sz * 8 * uint64(ft.Len())
+		default:
+			if _, ok := basicOf(v.Type()); !ok {
+				return 0, fmt.Errorf("sizeOf elements of type %s have a dynamic size", t.String())
+			}
+			nbits += tags.nbits
+		}
+	}
+
+	if nbits%8 != 0 {
+		return 0, fmt.Errorf("left-over bits in structure %s", t.String())
+	}
+
+	return nbits / 8, nil
+}
+
+// parseTags interprets the structex annotations of a field of type typ.
+func parseTags(st reflect.StructTag, typ types.Type) (fieldTags, error) {
+	t := fieldTags{endian: undefined, tag: st}
+
+	elem := typ
+	switch u := typ.Underlying().(type) {
+	case *types.Array:
+		elem = u.Elem()
+	case *types.Slice:
+		elem = u.Elem()
+	default:
+		if b, ok := basicOf(typ); ok {
+			t.nbits = b.bits
+		}
+	}
+
+	for _, attr := range tag.Parse(st) {
+		switch strings.ToLower(attr.Key) {
+		case "little":
+			t.endian = little
+
+		case "big":
+			t.endian = big
+
+		case "bitfield":
+			if nbs := strings.Split(attr.Value, ",")[0]; len(nbs) != 0 {
+				b, ok := basicOf(elem)
+				if !ok || b.float {
+					return t, fmt.Errorf("invalid tag '%s' for %s", st, typ.String())
+				}
+				if b.boolean {
+					t.nbits = 1
+				} else {
+					nbits, err := strconv.ParseInt(nbs, 0, int(b.bits))
+					if err != nil || nbits < 0 {
+						return t, fmt.Errorf("invalid tag '%s' for %s", st, typ.String())
+					}
+					t.nbits = uint64(nbits)
+				}
+			}
+			t.reserved = strings.Contains(attr.Value, "reserved")
+
+		case "sizeof":
+			t.layout = sizeOf
+			t.target = strings.Split(attr.Value, ",")[0]
+			t.relative = strings.Contains(attr.Value, "relative")
+			if strings.Contains(attr.Value, "=") {
+				return t, fmt.Errorf("sizeOf options other than relative not supported by structexgen")
+			}
+			if t.target == "$rest" {
+				return t, fmt.Errorf("sizeOf of the remainder of a structure not supported by structexgen")
+			}
+
+		case "countof":
+			t.layout = countOf
+			t.target = attr.Value
+			if strings.Contains(attr.Value, ",") {
+				return t, fmt.Errorf("countOf options not supported by structexgen")
+			}
+
+		case "truncate":
+			t.truncate = true
+
+		case "float":
+			return t, fmt.Errorf("float annotation not supported by structexgen")
+
+		case "bitorder":
+			return t, fmt.Errorf("bitorder annotation not supported by structexgen")
+
+		case "const":
+			return t, fmt.Errorf("const annotation not supported by structexgen")
+
+		case "checksum":
+			return t, fmt.Errorf("checksum annotation not supported by structexgen")
+
+		case "if":
+			return t, fmt.Errorf("if annotation not supported by structexgen")
+
+		case "switch":
+			return t, fmt.Errorf("switch annotation not supported by structexgen")
+
+		case "offset":
+			return t, fmt.Errorf("offset annotation not supported by structexgen")
+
+		case "greedy", "rest":
+			return t, fmt.Errorf("greedy annotation not supported by structexgen")
+
+		case "until", "terminator":
+			return t, fmt.Errorf("%s annotation not supported by structexgen", strings.ToLower(attr.Key))
+
+		case "align":
+			align, err := strconv.ParseInt(attr.Value, 0, 64)
+			if err != nil || align < 0 {
+				return t, fmt.Errorf("invalid tag '%s' for %s", st, typ.String())
+			}
+			t.align = uint64(align)
+		}
+	}
+
+	if b, ok := basicOf(typ); ok && t.nbits > b.bits {
+		return t, fmt.Errorf("bitfield of %d bits exceeds field size of %d bits", t.nbits, b.bits)
+	}
+
+	if b, ok := basicOf(typ); !ok {
+		if u, ok := typ.Underlying().(*types.Basic); ok {
+			if u.Kind() == types.Int || u.Kind() == types.Uint {
+				return t, fmt.Errorf("field type %s has a platform dependent size; use a sized integer type", typ.String())
+			}
+			return t, fmt.Errorf("field type %s unsupported", typ.String())
+		}
+	} else if t.nbits == 0 {
+		t.nbits = b.bits
+	}
+
+	return t, nil
+}
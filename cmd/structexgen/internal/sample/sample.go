@@ -0,0 +1,97 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package sample holds annotated structures used to verify the code
+// generated by structexgen against the reflective structex path.
+package sample
+
+import "github.com/HewlettPackard/structex"
+
+//go:generate go run github.com/HewlettPackard/structex/cmd/structexgen -type=Inquiry,Page -output=sample_structex.go -test
+
+type Inquiry struct {
+	PeripheralDeviceType uint8  `bitfield:"5"`
+	PeripheralQualifier  uint8  `bitfield:"3"`
+	Reserved0            uint8  `bitfield:"6,reserved"`
+	LUCong               bool   `bitfield:"1"`
+	RMB                  bool   `bitfield:"1"`
+	Version              uint8  // Byte 2
+	Length               uint16 `big:""`
+	Signed               int8   `bitfield:"3"`
+	Pad                  uint8  `bitfield:"5"`
+	Aligned              uint32 `align:"4"`
+	Little               uint64 `little:""`
+	Default              int16
+	Temperature          float32 `big:""`
+	Energy               float64
+}
+
+type Descriptor struct {
+	Code  uint8  `bitfield:"4"`
+	Flags uint8  `bitfield:"4"`
+	Value uint16 `big:""`
+}
+
+type Page struct {
+	Count       uint8  `countOf:"Descriptors"`
+	Size        uint16 `sizeOf:"Data"`
+	Slots       uint8  `countOf:"Fixed"`
+	Descriptors []Descriptor
+	Data        []uint16 `big:""`
+	Fixed       [4]int8  `bitfield:"6"`
+	Header      Descriptor
+	Updated     Stamp   `big:""`
+	Trailer     [8]byte `truncate:""`
+}
+
+// Stamp is a 24-bit time stamp that encodes itself.
+type Stamp uint32
+
+func (s Stamp) MarshalStructex(w structex.BitWriter, f structex.Field) error {
+	for i := uint64(0); i < 3; i++ {
+		shift := 8 * i
+		if f.BigEndian {
+			shift = 8 * (2 - i)
+		}
+		if err := w.WriteBits(uint64(s>>shift)&0xFF, 8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stamp) UnmarshalStructex(r structex.BitReader, f structex.Field) error {
+	*s = 0
+	for i := uint64(0); i < 3; i++ {
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		if f.BigEndian {
+			*s = *s<<8 | Stamp(v)
+		} else {
+			*s |= Stamp(v) << (8 * i)
+		}
+	}
+	return nil
+}
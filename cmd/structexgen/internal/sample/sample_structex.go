@@ -0,0 +1,534 @@
+// Code generated by structexgen; DO NOT EDIT.
+
+package sample
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/HewlettPackard/structex"
+)
+
+// MarshalStructex implements structex.Marshaler.
+func (s Inquiry) MarshalStructex(w structex.BitWriter, f structex.Field) error {
+	{
+		v := uint64(s.PeripheralDeviceType)
+		var err error
+		if v, err = w.Fit(v, 5, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 5); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.PeripheralQualifier)
+		var err error
+		if v, err = w.Fit(v, 3, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 3); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Reserved0)
+		var err error
+		if v, err = w.Reserved(v, 6); err != nil {
+			return err
+		}
+		if v, err = w.Fit(v, 6, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 6); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(0)
+		if s.LUCong {
+			v = 1
+		}
+		if err := w.WriteBits(v, 1); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(0)
+		if s.RMB {
+			v = 1
+		}
+		if err := w.WriteBits(v, 1); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Version)
+		if err := w.WriteBits(v, 8); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Length)
+		if err := w.WriteBigEndian(v, 16); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Signed)
+		var err error
+		if v, err = w.Fit(v, 3, true); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 3); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Pad)
+		var err error
+		if v, err = w.Fit(v, 5, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 5); err != nil {
+			return err
+		}
+	}
+	if err := w.Align(4); err != nil {
+		return err
+	}
+	{
+		v := uint64(s.Aligned)
+		write := w.WriteBits
+		if w.BigEndian() {
+			write = w.WriteBigEndian
+		}
+		if err := write(v, 32); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Little)
+		if err := w.WriteBits(v, 64); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Default)
+		write := w.WriteBits
+		if w.BigEndian() {
+			write = w.WriteBigEndian
+		}
+		if err := write(v, 16); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(math.Float32bits(float32(s.Temperature)))
+		if err := w.WriteBigEndian(v, 32); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(math.Float64bits(float64(s.Energy)))
+		write := w.WriteBits
+		if w.BigEndian() {
+			write = w.WriteBigEndian
+		}
+		if err := write(v, 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalStructex implements structex.Unmarshaler.
+func (s *Inquiry) UnmarshalStructex(r structex.BitReader, f structex.Field) error {
+	{
+		v, err := r.ReadBits(5)
+		if err != nil {
+			return err
+		}
+		s.PeripheralDeviceType = uint8(v)
+	}
+	{
+		v, err := r.ReadBits(3)
+		if err != nil {
+			return err
+		}
+		s.PeripheralQualifier = uint8(v)
+	}
+	{
+		v, err := r.ReadBits(6)
+		if err != nil {
+			return err
+		}
+		if err := r.Reserved("Reserved0", v, 6); err != nil {
+			return err
+		}
+		s.Reserved0 = uint8(v)
+	}
+	{
+		v, err := r.ReadBits(1)
+		if err != nil {
+			return err
+		}
+		s.LUCong = v == 1
+	}
+	{
+		v, err := r.ReadBits(1)
+		if err != nil {
+			return err
+		}
+		s.RMB = v == 1
+	}
+	{
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		s.Version = uint8(v)
+	}
+	{
+		v, err := r.ReadBigEndian(16)
+		if err != nil {
+			return err
+		}
+		s.Length = uint16(v)
+	}
+	{
+		v, err := r.ReadBits(3)
+		if err != nil {
+			return err
+		}
+		if v>>2 == 1 {
+			s.Signed = int8(int64(v) - 7 - 1)
+		} else {
+			s.Signed = int8(v)
+		}
+	}
+	{
+		v, err := r.ReadBits(5)
+		if err != nil {
+			return err
+		}
+		s.Pad = uint8(v)
+	}
+	if err := r.Align(4); err != nil {
+		return err
+	}
+	{
+		read := r.ReadBits
+		if r.BigEndian() {
+			read = r.ReadBigEndian
+		}
+		v, err := read(32)
+		if err != nil {
+			return err
+		}
+		s.Aligned = uint32(v)
+	}
+	{
+		v, err := r.ReadBits(64)
+		if err != nil {
+			return err
+		}
+		s.Little = uint64(v)
+	}
+	{
+		read := r.ReadBits
+		if r.BigEndian() {
+			read = r.ReadBigEndian
+		}
+		v, err := read(16)
+		if err != nil {
+			return err
+		}
+		if v>>15 == 1 {
+			s.Default = int16(int64(v) - 65535 - 1)
+		} else {
+			s.Default = int16(v)
+		}
+	}
+	{
+		v, err := r.ReadBigEndian(32)
+		if err != nil {
+			return err
+		}
+		s.Temperature = float32(math.Float32frombits(uint32(v)))
+	}
+	{
+		read := r.ReadBits
+		if r.BigEndian() {
+			read = r.ReadBigEndian
+		}
+		v, err := read(64)
+		if err != nil {
+			return err
+		}
+		s.Energy = float64(math.Float64frombits(uint64(v)))
+	}
+	return nil
+}
+
+// MarshalStructex implements structex.Marshaler.
+func (s Page) MarshalStructex(w structex.BitWriter, f structex.Field) error {
+	{
+		v := uint64(s.Count)
+		if v == 0 {
+			v = uint64(len(s.Descriptors))
+		}
+		var err error
+		if v, err = w.Fit(v, 8, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 8); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Size)
+		if v == 0 && len(s.Data) != 0 {
+			v = uint64(len(s.Data)) * 2
+		}
+		var err error
+		if v, err = w.Fit(v, 16, false); err != nil {
+			return err
+		}
+		write := w.WriteBits
+		if w.BigEndian() {
+			write = w.WriteBigEndian
+		}
+		if err := write(v, 16); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Slots)
+		if v == 0 {
+			v = uint64(len(s.Fixed))
+		}
+		var err error
+		if v, err = w.Fit(v, 8, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 8); err != nil {
+			return err
+		}
+	}
+	{
+		n := len(s.Descriptors)
+		if v := uint64(s.Count); v != 0 {
+			if v < uint64(n) {
+				n = int(v)
+			}
+		}
+		for i := 0; i < n; i++ {
+			if err := s.Descriptors[i].MarshalStructex(w, structex.Field{BigEndian: w.BigEndian()}); err != nil {
+				return err
+			}
+		}
+	}
+	{
+		n := len(s.Data)
+		if v := uint64(s.Size); v != 0 {
+			v /= 2
+			if v < uint64(n) {
+				n = int(v)
+			}
+		}
+		for i := 0; i < n; i++ {
+			{
+				v := uint64(s.Data[i])
+				if err := w.WriteBigEndian(v, 16); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	{
+		n := len(s.Fixed)
+		if v := uint64(s.Slots); v != 0 {
+			if v < uint64(n) {
+				n = int(v)
+			}
+		}
+		for i := 0; i < n; i++ {
+			{
+				v := uint64(s.Fixed[i])
+				var err error
+				if v, err = w.Fit(v, 6, true); err != nil {
+					return err
+				}
+				if err := w.WriteBits(v, 6); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := s.Header.MarshalStructex(w, structex.Field{BigEndian: w.BigEndian()}); err != nil {
+		return err
+	}
+	if err := s.Updated.MarshalStructex(w, structex.Field{BigEndian: true, Bits: 32, Tag: "big:\"\""}); err != nil {
+		return err
+	}
+	{
+		for i := range s.Trailer {
+			{
+				v := uint64(s.Trailer[i])
+				if err := w.WriteBits(v, 8); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalStructex implements structex.Unmarshaler.
+func (s *Page) UnmarshalStructex(r structex.BitReader, f structex.Field) error {
+	var layoutDescriptors, layoutData uint64
+
+	{
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		s.Count = uint8(v)
+		layoutDescriptors = v
+	}
+	{
+		read := r.ReadBits
+		if r.BigEndian() {
+			read = r.ReadBigEndian
+		}
+		v, err := read(16)
+		if err != nil {
+			return err
+		}
+		s.Size = uint16(v)
+		layoutData = v
+	}
+	{
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		s.Slots = uint8(v)
+	}
+	if err := r.SliceLen(layoutDescriptors); err != nil {
+		return err
+	}
+	s.Descriptors = make([]Descriptor, layoutDescriptors)
+	for i := range s.Descriptors {
+		if err := s.Descriptors[i].UnmarshalStructex(r, structex.Field{BigEndian: r.BigEndian()}); err != nil {
+			return err
+		}
+	}
+	if layoutData%2 != 0 {
+		return fmt.Errorf("Slice with size %d of slice is a non-multiple of structure size %d", layoutData, 2)
+	}
+	if err := r.SliceLen(layoutData / 2); err != nil {
+		return err
+	}
+	s.Data = make([]uint16, layoutData/2)
+	for i := range s.Data {
+		{
+			v, err := r.ReadBigEndian(16)
+			if err != nil {
+				return err
+			}
+			s.Data[i] = uint16(v)
+		}
+	}
+	for i := range s.Fixed {
+		{
+			v, err := r.ReadBits(6)
+			if err != nil {
+				return err
+			}
+			if v>>5 == 1 {
+				s.Fixed[i] = int8(int64(v) - 63 - 1)
+			} else {
+				s.Fixed[i] = int8(v)
+			}
+		}
+	}
+	if err := s.Header.UnmarshalStructex(r, structex.Field{BigEndian: r.BigEndian()}); err != nil {
+		return err
+	}
+	if err := s.Updated.UnmarshalStructex(r, structex.Field{BigEndian: true, Bits: 32, Tag: "big:\"\""}); err != nil {
+		return err
+	}
+	for i := range s.Trailer {
+		{
+			v, err := r.ReadBits(8)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			s.Trailer[i] = byte(v)
+		}
+	}
+	return nil
+}
+
+// MarshalStructex implements structex.Marshaler.
+func (s Descriptor) MarshalStructex(w structex.BitWriter, f structex.Field) error {
+	{
+		v := uint64(s.Code)
+		var err error
+		if v, err = w.Fit(v, 4, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 4); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Flags)
+		var err error
+		if v, err = w.Fit(v, 4, false); err != nil {
+			return err
+		}
+		if err := w.WriteBits(v, 4); err != nil {
+			return err
+		}
+	}
+	{
+		v := uint64(s.Value)
+		if err := w.WriteBigEndian(v, 16); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalStructex implements structex.Unmarshaler.
+func (s *Descriptor) UnmarshalStructex(r structex.BitReader, f structex.Field) error {
+	{
+		v, err := r.ReadBits(4)
+		if err != nil {
+			return err
+		}
+		s.Code = uint8(v)
+	}
+	{
+		v, err := r.ReadBits(4)
+		if err != nil {
+			return err
+		}
+		s.Flags = uint8(v)
+	}
+	{
+		v, err := r.ReadBigEndian(16)
+		if err != nil {
+			return err
+		}
+		s.Value = uint16(v)
+	}
+	return nil
+}
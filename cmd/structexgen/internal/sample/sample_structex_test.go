@@ -0,0 +1,27 @@
+// Code generated by structexgen; DO NOT EDIT.
+
+package sample
+
+import (
+	"testing"
+
+	"github.com/HewlettPackard/structex"
+)
+
+func TestStructexInquiry(t *testing.T) {
+	if err := structex.CheckGenerated(new(Inquiry), new(Page), new(Descriptor)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStructexPage(t *testing.T) {
+	if err := structex.CheckGenerated(new(Page), new(Inquiry), new(Descriptor)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStructexDescriptor(t *testing.T) {
+	if err := structex.CheckGenerated(new(Descriptor), new(Inquiry), new(Page)); err != nil {
+		t.Error(err)
+	}
+}
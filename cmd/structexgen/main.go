@@ -0,0 +1,143 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Structexgen generates reflection-free encoders and decoders for structures
+annotated with structex tags.
+
+Given the name of one or more structure types, structexgen writes a Go
+source file implementing structex.Marshaler and structex.Unmarshaler for
+each type and for every structure type of the same package nested within
+them. structex.Encode and structex.Decode use the generated methods in place
+of reflection, with the same bitfield, endian, sizeOf/countOf, align and
+truncate semantics.
+
+Typical use is a go:generate directive next to the annotated types
+
+	//go:generate go run github.com/HewlettPackard/structex/cmd/structexgen -type=Inquiry
+
+Usage:
+
+	structexgen [flags] -type T[,T...] [directory]
+
+The flags are:
+
+	-type
+		Comma-separated list of structure type names; must be set.
+	-output
+		Output file name; default <directory>/<type>_structex.go
+	-test
+		Also write <output>_test.go, a test that cross-checks the generated
+		methods against the reflective structex path.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
+	output    = flag.String("output", "", "output file name; default <directory>/<type>_structex.go")
+	withTest  = flag.Bool("test", false, "also write a test cross-checking the generated code against the reflective path")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of structexgen:\n")
+	fmt.Fprintf(os.Stderr, "\tstructexgen [flags] -type T[,T...] [directory]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("structexgen: ")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if len(*typeNames) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if args := flag.Args(); len(args) == 1 {
+		dir = args[0]
+	} else if len(args) > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	names := strings.Split(*typeNames, ",")
+
+	outputName := *output
+	if outputName == "" {
+		outputName = filepath.Join(dir, strings.ToLower(names[0])+"_structex.go")
+	}
+
+	if err := run(dir, names, outputName, *withTest); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(dir string, names []string, outputName string, withTest bool) error {
+	pkg, err := loadPackage(dir)
+	if err != nil {
+		return err
+	}
+
+	g := newGenerator(pkg)
+	if err := g.generate(names); err != nil {
+		return err
+	}
+
+	if err := writeSource(outputName, g.source()); err != nil {
+		return err
+	}
+
+	if withTest {
+		testName := strings.TrimSuffix(outputName, ".go") + "_test.go"
+		if err := writeSource(testName, g.testSource()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeSource(name string, src []byte) error {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("internal error: invalid generated code for %s: %v\n%s", name, err, src)
+	}
+
+	return ioutil.WriteFile(name, formatted, 0644)
+}
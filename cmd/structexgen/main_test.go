@@ -0,0 +1,111 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGolden checks that the committed sample output is what structexgen
+// produces today. Regenerate with `go generate ./...` after changes.
+func TestGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structexgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "sample_structex.go")
+	if err := run("internal/sample", []string{"Inquiry", "Page"}, output, true); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, name := range []string{"sample_structex.go", "sample_structex_test.go"} {
+		expected, err := ioutil.ReadFile(filepath.Join("internal/sample", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("Generated %s is out of date; run go generate", name)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"type T struct { A int }", "platform dependent size"},
+		{"type T struct { A complex64 }", "unsupported"},
+		{"type T struct { A float32 `float:\"half\"` }", "not supported"},
+		{"type T struct { A uint8 `bitorder:\"msb\"` }", "not supported"},
+		{"type T struct { A uint16 `const:\"0xAA55\"` }", "not supported"},
+		{"type T struct { A uint8 `checksum:\"sum8\"` }", "not supported"},
+		{"type T struct { V uint8; A uint8 `if:\"V>=2\"` }", "not supported"},
+		{"type T struct { V uint8; A interface{} `switch:\"V\"` }", "not supported"},
+		{"type T struct { V uint8; A uint8 `offset:\"V\"` }", "not supported"},
+		{"type T struct { N uint8 `countOf:\"A,bias=1\"`; A []uint8 }", "not supported"},
+		{"type T struct { N uint8 `sizeOf:\"A,scale=4\"`; A []uint32 }", "not supported"},
+		{"type T struct { N uint8 `sizeOf:\"$rest\"`; A []uint8 }", "not supported"},
+		{"type T struct { A []uint8 `greedy:\"\"` }", "not supported"},
+		{"type T struct { A []uint8 `terminator:\"0\"` }", "not supported"},
+		{"type T struct { N uint8 `sizeOf:\"A\"`; A struct{ B uint8 } }", "not supported"},
+		{"type T struct { a uint8 }", "unexported"},
+		{"type T struct { A uint8 `bitfield:\"9\"` }", "exceeds"},
+		{"type T struct { A uint8 `bitfield:\"x\"` }", "invalid tag"},
+		{"type T struct { A []uint8; N uint8 `countOf:\"A\"` }", "must precede"},
+		{"type T struct { N uint8 `countOf:\"B\"` }", "outside of T"},
+		{"type T struct { A struct { B uint8 } }", "named type"},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "structexgen")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		src := "package p\n\n" + test.src + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, "p.go"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err = run(dir, []string{"T"}, filepath.Join(dir, "t_structex.go"), false)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Unexpected error for '%s': Expected: %s Actual: %v", test.src, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// condition describes the presence of a field annotated with
+// `if:"Version>=2"` or `if:"Flags.HasExt"`.
+type condition struct {
+	path   []string // Referenced field, and any nested fields, by name
+	op     string   // Comparison operator, empty to test for a non-zero value
+	negate bool     // Test for a zero value, i.e. `if:"!Flags.HasExt"`
+	value  int64    // Operand of the comparison
+	large  uint64   // Operand if beyond the range of int64
+}
+
+// operators in the order they are matched
+var operators = []string{"==", "!=", "<=", ">=", "<", ">", "&"}
+
+func parseCondition(val string) (*condition, bool) {
+	c := &condition{}
+	lhs := strings.TrimSpace(val)
+
+	for _, op := range operators {
+		if i := strings.Index(lhs, op); i >= 0 {
+			rhs := strings.TrimSpace(lhs[i+len(op):])
+			lhs, c.op = strings.TrimSpace(lhs[:i]), op
+
+			if !c.parseOperand(rhs) {
+				return nil, false
+			}
+			break
+		}
+	}
+
+	if len(c.op) == 0 && strings.HasPrefix(lhs, "!") {
+		c.negate = true
+		lhs = strings.TrimSpace(lhs[1:])
+	}
+
+	c.path = strings.Split(lhs, ".")
+	for _, name := range c.path {
+		if !isIdentifier(name) {
+			return nil, false
+		}
+	}
+
+	return c, true
+}
+
+func (c *condition) parseOperand(s string) bool {
+	switch s {
+	case "true":
+		c.value = 1
+		return true
+	case "false":
+		c.value = 0
+		return true
+	}
+
+	if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+		c.value = v
+		return true
+	}
+
+	if v, err := strconv.ParseUint(s, 0, 64); err == nil {
+		c.large = v
+		return true
+	}
+
+	return false
+}
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// evaluate reports if the condition holds for the fields already
+// transcoded, as found by fieldByName.
+func (c *condition) evaluate(t *transcoder) (bool, error) {
+	v, _ := t.fieldByName(c.path[0])
+	if !v.IsValid() {
+		return false, fmt.Errorf("cannot locate field '%s' referenced by condition: %w", c.path[0], ErrTag)
+	}
+
+	return c.holds(v, c.path[1:])
+}
+
+// holds reports if the condition holds for the field found by following
+// the names of path from v.
+func (c *condition) holds(v reflect.Value, path []string) (bool, error) {
+	for _, name := range path {
+		for v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return false, fmt.Errorf("condition references '%s' of non-structure type %s: %w", name, v.Type().String(), ErrTag)
+		}
+		if v = v.FieldByName(name); !v.IsValid() {
+			return false, fmt.Errorf("cannot locate field '%s' referenced by condition: %w", name, ErrTag)
+		}
+	}
+
+	var cmp int
+	var bits uint64
+
+	switch v.Kind() {
+	case reflect.Bool:
+		bits = map[bool]uint64{true: 1}[v.Bool()]
+		cmp = c.compareUnsigned(bits)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits = uint64(v.Int())
+		cmp = c.compareSigned(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits = v.Uint()
+		cmp = c.compareUnsigned(bits)
+	default:
+		return false, fmt.Errorf("condition references field of unsupported type %s: %w", v.Type().String(), ErrTag)
+	}
+
+	switch c.op {
+	case "":
+		return (bits != 0) != c.negate, nil
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "&":
+		return bits&c.operand() != 0, nil
+	}
+
+	return false, fmt.Errorf("condition operator '%s' not recognized: %w", c.op, ErrTag)
+}
+
+// operand returns the bits of the operand.
+func (c *condition) operand() uint64 {
+	if c.large != 0 {
+		return c.large
+	}
+	return uint64(c.value)
+}
+
+// compareSigned returns -1, 0 or 1 as v is less than, equal to or greater
+// than the operand.
+func (c *condition) compareSigned(v int64) int {
+	if c.large != 0 || v < c.value {
+		return -1
+	}
+	if v > c.value {
+		return 1
+	}
+	return 0
+}
+
+func (c *condition) compareUnsigned(v uint64) int {
+	switch {
+	case c.large == 0 && c.value < 0:
+		return 1
+	case v < c.operand():
+		return -1
+	case v > c.operand():
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type condFlags struct {
+	HasExt bool  `bitfield:"1"`
+	Rsvd   uint8 `bitfield:"7,reserved"`
+}
+
+type condRecord struct {
+	Version uint8
+	Flags   condFlags
+	Length  uint16 `if:"Version>=2"`
+	Ext     uint32 `if:"Flags.HasExt"`
+	Kind    int8
+	Neg     uint8 `if:"Kind<0"`
+	Mask    uint8 `if:"Version&0x80"`
+	NoExt   uint8 `if:"!Flags.HasExt"`
+}
+
+func TestCondition(t *testing.T) {
+	tests := []struct {
+		s condRecord
+		b []byte
+	}{
+		{
+			condRecord{Version: 2, Flags: condFlags{HasExt: true}, Length: 0x1234, Ext: 0xDEADBEEF, Kind: -1, Neg: 7},
+			[]byte{0x02, 0x01, 0x34, 0x12, 0xEF, 0xBE, 0xAD, 0xDE, 0xFF, 0x07},
+		},
+		{
+			condRecord{Version: 1, Kind: 1, NoExt: 9},
+			[]byte{0x01, 0x00, 0x01, 0x09},
+		},
+		{
+			condRecord{Version: 0x81, Length: 0x0102, Mask: 3, NoExt: 4},
+			[]byte{0x81, 0x00, 0x02, 0x01, 0x00, 0x03, 0x04},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := EncodeByteBuffer(test.s)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		if !bytes.Equal(b, test.b) {
+			t.Errorf("Invalid conditional encoding:\nExpected: %#02x\nActual:   %#02x", test.b, b)
+		}
+
+		sz, err := Size(test.s)
+		if err != nil || sz != uint64(len(test.b)) {
+			t.Errorf("Invalid conditional size: Expected: %d Actual: %d %v", len(test.b), sz, err)
+		}
+
+		var s condRecord
+		if err := Decode(bytes.NewReader(test.b), &s); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if s != test.s {
+			t.Errorf("Invalid conditional decode:\nExpected: %+v\nActual:   %+v", test.s, s)
+		}
+	}
+}
+
+type condEntry struct {
+	Type  uint8
+	Value uint16 `if:"Type==1"`
+	Wide  uint32 `if:"Version>=2"`
+}
+
+type condTable struct {
+	Version uint8
+	Count   uint8 `countOf:"Entries"`
+	Entries []condEntry
+}
+
+func TestConditionElements(t *testing.T) {
+	// Elements differ in size, and depend on the enclosing structure
+	s := condTable{Version: 2, Entries: []condEntry{{Type: 0, Wide: 1}, {Type: 1, Value: 0x0302, Wide: 4}}}
+	expected := []byte{0x02, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04, 0x00, 0x00, 0x00}
+
+	if sz, err := Size(s); err != nil || sz != uint64(len(expected)) {
+		t.Errorf("Invalid size: Expected: %d Actual: %d %v", len(expected), sz, err)
+	}
+
+	b, err := EncodeByteBuffer(s)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Invalid encoding:\nExpected: %#02x\nActual:   %#02x", expected, b)
+	}
+}
+
+func TestConditionError(t *testing.T) {
+	tags := []interface{}{
+		struct {
+			A uint8 `if:"1A"`
+		}{},
+		struct {
+			A uint8 `if:"A=="`
+		}{},
+		struct {
+			A uint8 `if:"A==x"`
+		}{},
+		struct {
+			A uint8 `if:"A..B"`
+		}{},
+	}
+
+	for _, test := range tags {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+	}
+
+	refs := []interface{}{
+		struct {
+			A uint8 `if:"Missing"`
+		}{},
+		struct {
+			F float32
+			A uint8 `if:"F"`
+		}{},
+		struct {
+			V uint8
+			A uint8 `if:"V.X"`
+		}{},
+	}
+
+	for _, test := range refs {
+		err := Encode(new(bytes.Buffer), test)
+
+		var fe *FieldError
+		if !errors.Is(err, ErrTag) || !errors.As(err, &fe) || fe.Path != "A" {
+			t.Errorf("Expected condition error for %T: Actual: %v", test, err)
+		}
+
+		if errs := Validate(test); len(errs) != 1 {
+			t.Errorf("Expected a validation error for %T: Actual: %v", test, errs)
+		}
+	}
+}
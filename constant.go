@@ -0,0 +1,120 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// constant is the value of a field annotated with const, either an integer
+// or, quoted in the annotation, a sequence of bytes.
+type constant struct {
+	set   bool
+	value uint64
+	bytes []byte // Quoted constant of a string or byte array field
+}
+
+// parseConstant parses the value of a const annotation on a field of typ,
+// i.e. `const:"0xAA55"` or `const:"'EFI PART'"`.
+func parseConstant(val string, typ reflect.Type) (constant, bool) {
+	if len(val) >= 2 && strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'") {
+		b := []byte(val[1 : len(val)-1])
+
+		switch {
+		case typ.Kind() == reflect.String && len(b) != 0:
+		case typ.Kind() == reflect.Array && typ.Elem().Kind() == reflect.Uint8 && typ.Len() == len(b):
+		default:
+			return constant{}, false
+		}
+
+		return constant{set: true, bytes: b}, true
+	}
+
+	var v uint64
+	var err error
+
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(val, 0, typ.Bits())
+		v = uint64(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err = strconv.ParseUint(val, 0, typ.Bits())
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(val)
+		if b {
+			v = 1
+		}
+	default:
+		return constant{}, false
+	}
+
+	if err != nil {
+		return constant{}, false
+	}
+
+	return constant{set: true, value: v}, true
+}
+
+// fits reports if the integer constant can be held by a field of nbits.
+func (c *constant) fits(nbits uint64, signed bool) bool {
+	if c.bytes != nil || nbits >= 64 {
+		return true
+	}
+
+	if signed {
+		v := int64(c.value)
+		return v >= int64(-1)<<(nbits-1) && v <= int64(1)<<(nbits-1)-1
+	}
+
+	return c.value < 1<<nbits
+}
+
+// check compares the value read for a field of nbits with the constant.
+func (c *constant) check(value uint64, nbits uint64) error {
+	expected := c.value
+	if nbits < 64 {
+		expected &= 1<<nbits - 1
+	}
+
+	if value != expected {
+		return fmt.Errorf("Read %#x, expected constant %#x: %w", value, expected, ErrConstant)
+	}
+
+	return nil
+}
+
+// checkBytes compares the bytes read for a field with their encoding b of
+// the constant.
+func (c *constant) checkBytes(read []byte, b []byte) error {
+	if !bytes.Equal(read, b) {
+		return fmt.Errorf("Read %q, expected constant %q: %w", read, b, ErrConstant)
+	}
+
+	return nil
+}
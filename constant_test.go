@@ -0,0 +1,132 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type constHeader struct {
+	Signature [8]byte `const:"'EFI PART'"`
+	Revision  uint32
+	Anchor    string `const:"'_SM3_'"`
+	Version   uint8  `bitfield:"4" const:"3"`
+	Flags     uint8  `bitfield:"4"`
+	Magic     uint16 `const:"0xAA55" big:""`
+}
+
+var constBytes = []byte{
+	'E', 'F', 'I', ' ', 'P', 'A', 'R', 'T',
+	0x00, 0x00, 0x01, 0x00,
+	'_', 'S', 'M', '3', '_',
+	0x53,
+	0xAA, 0x55,
+}
+
+func TestConstantEncoder(t *testing.T) {
+	s := constHeader{Revision: 0x10000, Flags: 5}
+
+	b, err := EncodeByteBuffer(s)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	if !bytes.Equal(b, constBytes) {
+		t.Errorf("Invalid constant encoding:\nExpected: %q\nActual:   %q", constBytes, b)
+	}
+
+	// Constants are written regardless of the structure contents
+	s.Signature = [8]byte{'X'}
+	s.Anchor = "XYZ"
+	s.Version = 1
+	s.Magic = 0x1234
+
+	if b, err := EncodeByteBuffer(s); err != nil || !bytes.Equal(b, constBytes) {
+		t.Errorf("Constants not written: %q %v", b, err)
+	}
+}
+
+func TestConstantDecoder(t *testing.T) {
+	var s constHeader
+	if err := Decode(bytes.NewReader(constBytes), &s); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	if string(s.Signature[:]) != "EFI PART" || s.Anchor != "_SM3_" || s.Version != 3 || s.Magic != 0xAA55 || s.Flags != 5 {
+		t.Errorf("Invalid constant decode: %+v", s)
+	}
+
+	tests := []struct {
+		offset int
+		path   string
+	}{
+		{7, "Signature"},
+		{14, "Anchor"},
+		{17, "Version"},
+		{19, "Magic"},
+	}
+
+	for _, test := range tests {
+		b := append([]byte{}, constBytes...)
+		b[test.offset]++
+
+		err := Decode(bytes.NewReader(b), new(constHeader))
+
+		var fe *FieldError
+		if !errors.Is(err, ErrConstant) || !errors.As(err, &fe) || fe.Path != test.path {
+			t.Errorf("Expected constant error for field %s: Actual: %v", test.path, err)
+		}
+	}
+}
+
+func TestConstantTaggingError(t *testing.T) {
+	tests := []interface{}{
+		struct {
+			F float32 `const:"1"`
+		}{},
+		struct {
+			A [4]byte `const:"'EFI PART'"`
+		}{},
+		struct {
+			A uint8 `bitfield:"4" const:"0x10"`
+		}{},
+		struct {
+			A int8 `bitfield:"4" const:"-9"`
+		}{},
+		struct {
+			S string `string:"4" const:"'EFI PART'"`
+		}{},
+		struct {
+			A uint16 `const:"'AB'"`
+		}{},
+	}
+
+	for _, test := range tests {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+	}
+}
@@ -24,10 +24,10 @@ package structex
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"math"
-	"math/bits"
 	"reflect"
 )
 
@@ -36,17 +36,37 @@ type decoder struct {
 	currentByte uint8
 	byteOffset  uint64
 	bitOffset   uint64
+	bitOrder    bitOrder // Bit order of the partially read byte
+	customOrder bitOrder // Bit order of the Unmarshaler being called
 	transcoder  *transcoder
+	recording   bool          // Bytes read are appended to recorded
+	recorded    []byte        // Bytes of the structures being checksummed
+	at          *readerAt     // Random access reader of DecodeAt, if any
+	jumps       map[jump]bool // Fields at an offset being decoded by DecodeAt
+	limit       uint64        // Byte offset reads stop at, if not zero
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.limit != 0 && d.byteOffset >= d.limit {
+		return 0, errBound
+	}
+
+	b, err := d.reader.ReadByte()
+	if err == nil && d.recording {
+		d.recorded = append(d.recorded, b)
+	}
+
+	return b, err
 }
 
 func (d *decoder) read(nbits uint64) (uint64, error) {
 
 	if nbits == 0 {
-		return 0, fmt.Errorf("unsupported zero bit operation")
+		return 0, fmt.Errorf("unsupported zero bit operation: %w", ErrTag)
 	}
 
 	if nbits > 64 {
-		return 0, fmt.Errorf("bitfield exceeds 64-bit limitation")
+		return 0, fmt.Errorf("bitfield exceeds 64-bit limitation: %w", ErrTag)
 	}
 
 	var value uint64 = 0
@@ -68,7 +88,7 @@ func (d *decoder) read(nbits uint64) (uint64, error) {
 	}
 
 	for nbits != 0 {
-		b, err := d.reader.ReadByte()
+		b, err := d.readByte()
 		if err != nil {
 			return 0, err
 		}
@@ -91,6 +111,106 @@ func (d *decoder) read(nbits uint64) (uint64, error) {
 	return value, nil
 }
 
+// readMSB reads nbits starting with the most significant bit, consuming
+// bits from the high bit of each byte downwards.
+func (d *decoder) readMSB(nbits uint64) (uint64, error) {
+	if nbits == 0 {
+		return 0, fmt.Errorf("unsupported zero bit operation: %w", ErrTag)
+	}
+
+	if nbits > 64 {
+		return 0, fmt.Errorf("bitfield exceeds 64-bit limitation: %w", ErrTag)
+	}
+
+	var value uint64 = 0
+
+	for nbits != 0 {
+		if d.bitOffset == 0 {
+			b, err := d.readByte()
+			if err != nil {
+				return 0, err
+			}
+
+			d.currentByte = b
+			d.byteOffset += 1
+		}
+
+		n := 8 - d.bitOffset
+		if nbits < n {
+			n = nbits
+		}
+
+		bits := uint64(d.currentByte>>(8-d.bitOffset-n)) & (1<<n - 1)
+		value = value<<n | bits
+		d.bitOffset = (d.bitOffset + n) % 8
+		nbits -= n
+	}
+
+	return value, nil
+}
+
+// readOrdered reads nbits in the provided bit order. Bit orders cannot be
+// mixed within a single byte.
+func (d *decoder) readOrdered(nbits uint64, order bitOrder) (uint64, error) {
+	if d.bitOffset != 0 && d.bitOrder != order {
+		return 0, fmt.Errorf("Bit order changes within byte %d: %w", d.byteOffset-1, ErrTag)
+	}
+	d.bitOrder = order
+
+	if order == msbFirst {
+		return d.readMSB(nbits)
+	}
+	return d.read(nbits)
+}
+
+// readBits reads nbits in the bit and byte order of a field annotated with
+// tags. See encoder.writeValue.
+func (d *decoder) readBits(nbits uint64, tags *tags) (uint64, error) {
+	order := d.transcoder.bitOrder(tags)
+
+	if nbits > 8 && d.transcoder.bigEndian(tags) != (order == msbFirst) {
+		return d.readReversed(nbits, order)
+	}
+
+	return d.readOrdered(nbits, order)
+}
+
+// readReversed reads nbits in the byte order opposite to that natural for
+// the bit order. See encoder.writeReversed.
+func (d *decoder) readReversed(nbits uint64, order bitOrder) (uint64, error) {
+	var value uint64 = 0
+	var shift uint64 = 0
+
+	for nbits != 0 {
+		n := 8 - d.bitOffset
+		if nbits < n {
+			n = nbits
+		}
+
+		chunk, err := d.readOrdered(n, order)
+		if err != nil {
+			return 0, err
+		}
+
+		if order == msbFirst {
+			value |= chunk << shift
+			shift += n
+		} else {
+			value = value<<n | chunk
+		}
+		nbits -= n
+	}
+
+	return value, nil
+}
+
+func (d *decoder) position() (uint64, uint64) {
+	if d.bitOffset != 0 {
+		return d.byteOffset - 1, d.bitOffset
+	}
+	return d.byteOffset, 0
+}
+
 func (d *decoder) readValue(value reflect.Value, tags *tags) (uint64, error) {
 
 	if !value.CanSet() {
@@ -100,6 +220,9 @@ func (d *decoder) readValue(value reflect.Value, tags *tags) (uint64, error) {
 
 	nbits := uint64(0)
 	kind := value.Kind()
+	if kind == reflect.String {
+		return 0, d.readString(value, tags)
+	}
 	if kind == reflect.Bool {
 		nbits = 1
 	} else {
@@ -108,29 +231,33 @@ func (d *decoder) readValue(value reflect.Value, tags *tags) (uint64, error) {
 
 	if tags != nil {
 		if nbits < tags.bitfield.nbits {
-			return 0, fmt.Errorf("Field value of type %s has bitfield definition with %d bits, exceeding field size of %d bits.",
+			return 0, fmt.Errorf("Field value of type %s has bitfield definition with %d bits, exceeding field size of %d bits: %w",
 				value.Type().Kind().String(),
 				tags.bitfield.nbits,
-				nbits)
+				nbits,
+				ErrTag)
 		}
 		if tags.bitfield.nbits > 0 {
 			nbits = tags.bitfield.nbits
 		}
 	}
 
-	v, err := d.read(nbits)
+	offset, bit := d.position()
+
+	v, err := d.readBits(nbits, tags)
 	if err != nil {
 		return 0, err
 	}
 
-	if (tags != nil && tags.endian == big) || (d.transcoder.defaultEndianness == big && (tags != nil && tags.endian != little)) {
-		switch kind {
-		case reflect.Uint16, reflect.Int16:
-			v = uint64(bits.ReverseBytes16(uint16(v)))
-		case reflect.Uint32, reflect.Int32, reflect.Uint, reflect.Int:
-			v = uint64(bits.ReverseBytes32(uint32(v)))
-		case reflect.Uint64, reflect.Int64:
-			v = bits.ReverseBytes64(v)
+	if tags != nil && tags.bitfield.reserved && v != 0 {
+		if err := d.reserved(v, offset, bit); err != nil {
+			return 0, err
+		}
+	}
+
+	if tags != nil && tags.constant.set && tags.constant.bytes == nil {
+		if err := tags.constant.check(v, nbits); err != nil {
+			return 0, err
 		}
 	}
 
@@ -145,13 +272,61 @@ func (d *decoder) readValue(value reflect.Value, tags *tags) (uint64, error) {
 		} else {
 			value.SetInt(int64(v))
 		}
+	case reflect.Float32, reflect.Float64:
+		value.SetFloat(floatFromBits(v, kind, tags))
 	default:
-		return 0, fmt.Errorf("Unsupported read type %s", value.Kind().String())
+		return 0, fmt.Errorf("Unsupported read type %s: %w", value.Kind().String(), ErrTag)
 	}
 
 	return v, nil
 }
 
+// reserved handles reserved bits read with a non-zero value. Strict decoding
+// fails, otherwise the violation is recorded in any report requested.
+func (d *decoder) reserved(value uint64, offset uint64, bit uint64) error {
+	opts := &d.transcoder.options
+
+	if opts.Strict {
+		return fmt.Errorf("Reserved bits have value %#x: %w", value, ErrReserved)
+	}
+
+	if opts.Report != nil {
+		opts.Report.Reserved = append(opts.Report.Reserved, ReservedViolation{
+			Path:   d.transcoder.fieldPath(),
+			Offset: offset,
+			Bit:    bit,
+			Value:  value,
+		})
+	}
+
+	return nil
+}
+
+func (d *decoder) readString(value reflect.Value, tags *tags) error {
+	order := d.transcoder.bitOrder(tags)
+
+	b := make([]byte, tags.str.width)
+	for i := range b {
+		v, err := d.readOrdered(8, order)
+		if err != nil {
+			return err
+		}
+		b[i] = uint8(v)
+	}
+
+	value.SetString(stringFromBytes(b, tags))
+
+	if tags.constant.bytes != nil {
+		expected, err := stringBytes(string(tags.constant.bytes), tags)
+		if err != nil {
+			return err
+		}
+		return tags.constant.checkBytes(b, expected)
+	}
+
+	return nil
+}
+
 func (d *decoder) align(val alignment) error {
 	if d.bitOffset != 0 {
 		if _, err := d.read(8 - d.bitOffset); err != nil {
@@ -175,73 +350,167 @@ func (d *decoder) field(val reflect.Value, tags *tags) error {
 
 func (d *decoder) layout(val reflect.Value, ref *tagReference) error {
 	value, err := d.readValue(ref.value, ref.tags)
-	ref.tags.layout.value = value
+	ref.layoutValue = value
 
 	return err
 }
 
 func (d *decoder) array(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error {
-	isStruct := arr.Type().Elem().Kind() == reflect.Struct
+	elem := arr.Type().Elem()
+	isStruct := elem.Kind() == reflect.Struct || t.handles(elem)
 	for j := 0; j < arr.Len(); j++ {
+		offset, bit := d.position()
+
+		t.enter("", j)
 
 		if isStruct { // Recurse down into the struct
 			if err := t.transcode(arr.Index(j), tags); err != nil {
-				return err
+				return elementError(err, j, offset, bit)
 			}
 		} else {
 			if _, err := d.readValue(arr.Index(j), tags); err != nil {
-				if err == io.EOF && tags != nil && tags.truncate {
+				if errors.Is(err, io.EOF) && tags != nil && tags.truncate {
+					t.leave()
 					return nil
 				}
 
-				return err
+				return elementError(err, j, offset, bit)
 			}
 		}
+
+		t.leave()
+	}
+
+	if tags != nil && tags.constant.bytes != nil {
+		b := make([]byte, arr.Len())
+		for i := range b {
+			b[i] = uint8(arr.Index(i).Uint())
+		}
+		return tags.constant.checkBytes(b, tags.constant.bytes)
 	}
 
 	return nil
 }
 
 func (d *decoder) slice(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error {
+	if tags != nil && (tags.greedy || tags.sentinel != nil) {
+		return d.list(t, arr, tags)
+	}
+
 	length := uint64(arr.Len())
 
 	if ref != nil {
-		switch ref.tags.layout.format {
+		l := &ref.tags.layout
+
+		q, err := l.quantity(ref.layoutValue)
+		if err != nil {
+			return err
+		}
+
+		switch l.format {
 		case sizeOf:
-			sz, err := typeSize(arr.Type().Elem())
+			sz, err := t.elemSize(arr, tags)
 			if err != nil {
 				return err
 			}
 
-			if ref.tags.layout.value%sz != 0 {
+			// Bytes between the origin and the slice are not part of it
+			if l.origin != originNone {
+				start, _ := d.position()
+				if start-ref.origin > q {
+					return fmt.Errorf("Slice starting %d bytes from its origin exceeds size %d: %w", start-ref.origin, q, ErrOverflow)
+				}
+				q -= start - ref.origin
+			}
+
+			if q%sz != 0 {
 				return fmt.Errorf("Slice with size %d of slice is a non-multiple of structure size %d",
-					ref.tags.layout.value,
+					q,
 					sz)
 			}
 
-			length = ref.tags.layout.value / sz
+			length = q / sz
 		case countOf:
-			length = ref.tags.layout.value
+			length = q
 		default:
-			return fmt.Errorf("Slice size cannot be determined. Did you miss a field tag?")
+			return fmt.Errorf("Slice size cannot be determined. Did you miss a field tag? %w", ErrTag)
+		}
+
+		if err := d.SliceLen(length); err != nil {
+			return err
 		}
 
 		arr.Set(reflect.MakeSlice(arr.Type(), int(length), int(length)))
 	}
 
+	depth := len(t.path)
 	for j := 0; j < arr.Len(); j++ {
+		offset, bit := d.position()
+		t.enter("", j)
+
 		if err := t.transcode(arr.Index(j), tags); err != nil {
-			if err == io.EOF && tags != nil && tags.truncate {
+			if errors.Is(err, io.EOF) && tags != nil && tags.truncate {
+				t.path = t.path[:depth]
 				return nil
 			}
 
-			return err
+			return elementError(err, j, offset, bit)
 		}
+
+		t.leave()
 	}
 
 	return nil
 }
 
+// list decodes elements of the slice arr until its terminating element or,
+// if greedy, until the input ends at the boundary of an element. Input
+// ending part way through an element is an error.
+func (d *decoder) list(t *transcoder, arr reflect.Value, tags *tags) error {
+	arr.Set(reflect.MakeSlice(arr.Type(), 0, 0))
+
+	depth := len(t.path)
+	for j := 0; ; j++ {
+		offset, bit := d.position()
+		t.enter("", j)
+
+		elem := reflect.New(arr.Type().Elem()).Elem()
+		if err := t.transcode(elem, tags); err != nil {
+			if end, endBit := d.position(); tags.greedy && end == offset && endBit == bit && errors.Is(err, io.EOF) {
+				t.path = t.path[:depth]
+				return nil
+			}
+
+			return elementError(err, j, offset, bit)
+		}
+
+		if tags.sentinel != nil {
+			done, err := tags.sentinel.matches(elem)
+			if err != nil {
+				return elementError(err, j, offset, bit)
+			}
+			if done {
+				if tags.sentinel.include {
+					arr.Set(reflect.Append(arr, elem))
+				}
+				t.leave()
+				return nil
+			}
+		}
+
+		if end, endBit := d.position(); end == offset && endBit == bit {
+			return elementError(fmt.Errorf("Slice element consumed no input: %w", ErrTag), j, offset, bit)
+		}
+
+		if max := t.options.MaxSliceLen; max != 0 && uint64(j) >= max {
+			return elementError(fmt.Errorf("Slice length exceeds limit of %d elements: %w", max, ErrOverflow), j, offset, bit)
+		}
+
+		arr.Set(reflect.Append(arr, elem))
+		t.leave()
+	}
+}
+
 /*
 Decode reads data from a ByteReader into provided annotated structure.
 
@@ -255,10 +524,87 @@ Bitfields:
 
 	`bitfield:[size][,reserved]`
 
-	size       Specifies the size, in bits, of the field.
+	size       Specifies the size, in bits, of the field. Encoding a value
+	           that does not fit is an ErrOverflow error unless
+	           Options.Overflow truncates or saturates it.
 
 	reserved   Optional modifier that specifies the field contains reserved
-	           bits and should be encoded as zeros.
+	           bits and should be encoded as zeros, or as selected by
+	           Options.Reserved. Non-zero reserved bits
+	           are decoded as is, rejected with ErrReserved by Strict
+	           decoding or recorded in Options.Report.
+
+Endianness:
+
+	Fields wider than 8 bits are little-endian unless annotated with
+	`big:""` or the default is changed. Big-endian fields of any width are
+	transcoded most significant bits first, each part filling the remainder
+	of the current byte, so a 24-bit field at a byte boundary is three bytes
+	with the most significant first.
+
+Bit Order:
+
+	Bitfields are allocated from the least significant bit of each byte
+	unless annotated otherwise. The annotation applies to the field, or to
+	all fields of the nested structure it holds. A blank field of a
+	structure, i.e. _ struct{} `bitorder:"msb"`, applies to the structure.
+
+	`bitorder:[order]`
+
+	order      Either lsb (default) or msb. Fields in msb order are written
+	           most significant bit first, from the high bit of each byte.
+
+Floating Point:
+
+	Fields of type float32 and float64 are IEEE-754 binary32 and binary64
+	values. 16-bit wire formats are supported by annotating the field with
+	the format, which is converted to and from the field type.
+
+	`float:[format]`
+
+	format     Either half (or float16) for IEEE-754 binary16, or bfloat16.
+
+Strings:
+
+	String fields are fixed-width text, padded to the width of the field
+	when encoded and trimmed of the padding when decoded.
+
+	`string:[width][,pad=space|nul][,nul]`
+
+	width      Specifies the width, in bytes, of the field.
+
+	pad        Optional modifier for the byte padding the string, either
+	           space or nul (default).
+
+	nul        Optional modifier that specifies the string is terminated
+	           by a NUL within the field.
+
+Constants:
+
+	Signatures and magic values are declared with the const annotation.
+	The constant is always encoded regardless of the field contents, and
+	decoding fails with ErrConstant if the data does not match.
+
+	`const:[value]`
+
+	value      An integer for integer and boolean fields, i.e. 0xAA55, or a
+	           quoted sequence of bytes for string fields and byte arrays
+	           of the same length, i.e. 'EFI PART'. String fields without
+	           a width take that of the constant.
+
+Checksums:
+
+	Checksum fields are computed when encoding and verified when decoding,
+	failing with ErrChecksum, over the bytes of a range of fields of the
+	same structure.
+
+	`checksum:[algorithm][,from=Field][,to=Field]`
+
+	algorithm  One of crc8, crc16, crc32, crc32c, sum8, twos or internet,
+	           or an algorithm registered with RegisterChecksum.
+
+	from, to   Optional fields starting and ending the range, inclusive.
+	           Default to the first and last field of the structure.
 
 Dynamic Layouts:
 
@@ -266,7 +612,7 @@ Dynamic Layouts:
 	data layout is self described by other fields. To support such formats
 	two annotations are provided.
 
-	`sizeOf:"[Field][,relative]"`
+	`sizeOf:"[Field][,relative][,scale=N][,bias=N][,from=field|struct]"`
 
 	Field		Specifies that the field describes the size of Field within the
 				structure.
@@ -279,7 +625,7 @@ Dynamic Layouts:
 				the size of Field is relative to the field offset within
 				the structure. This is often used in T10.org documentation
 
-	`countOf:"[Field]"`
+	`countOf:"[Field][,scale=N][,bias=N]"`
 
 	Field		Specifies that the field describes the count of elements in
 				Field.
@@ -288,6 +634,72 @@ Dynamic Layouts:
 				used to limit the number elements in the array or slice of
 				name Field.
 
+	Sizes and counts are decoded as value * scale + bias, with scale=N and
+	bias=N options defaulting to 1 and 0. A sizeOf with from=field counts
+	the bytes from the end of the sizeOf field to the end of Field, and
+	with from=struct from the start of the enclosing structure.
+
+	A sizeOf of a structure, or of $rest for the remainder of the enclosing
+	structure, bounds decoding to that many bytes. Unknown trailing bytes
+	are skipped, and contents beyond the size return ErrOverflow.
+
+Conditional Fields:
+
+	Fields present only for some versions or flags are skipped when the
+	condition on a preceding field is false, leaving their value as is.
+
+	`if:"[Field][op][value]"`
+
+	Field		A preceding field, or a field of a nested structure such as
+				Flags.HasExt, of integer or bool type.
+
+	op		One of ==, !=, <, <=, >, >= or &. Without an operator, the
+				field is present when Field is non-zero, or zero if
+				prefixed with !.
+
+Unions:
+
+	Interface fields annotated with switch are decoded into the type
+	registered with RegisterCase for the value of a preceding integer field
+	of the same structure, failing with ErrUnknownCase if there is none.
+
+	`switch:"[Field]"`
+
+Pointers:
+
+	Pointer fields are decoded into the value they point to, allocated if
+	nil. Trailing pointer fields of a structure are optional and left nil
+	if the data ends before them.
+
+Offsets:
+
+	Fields annotated with offset are read from the offset held by another
+	field and can only be decoded by DecodeAt.
+
+Greedy Slices:
+
+	A final slice annotated with greedy, or rest, is decoded until the data
+	ends at an element boundary. Data ending part way through an element
+	returns ErrShortBuffer.
+
+	`greedy:""`
+
+Terminated Lists:
+
+	Slices annotated with until or terminator are decoded until the
+	terminating element, which is appended when encoding unless already
+	present.
+
+	`until:"[condition][,include]"`
+	`terminator:"[value][,include]"`
+
+	condition	A condition, as for if, on the fields of each element.
+
+	value		The value of the terminating element, or 0 for a structure
+				of all zeros.
+
+	include		The terminating element is kept in the decoded slice.
+
 Alignment:
 
 	Annotations can specified the byte-alignment requirement for structure
@@ -297,9 +709,28 @@ Alignment:
 	`align:"[value]"`
 
 	value		An integer value specifying the byte alignment of the field.
-				Invalid non-zero alignments panic.
+				Invalid alignments are reported as a TaggingError.
+
+Custom Types:
+
+	Values implementing Unmarshaler, such as structures with code generated
+	by structexgen or types structex cannot otherwise express, decode
+	themselves in place of reflection. The Unmarshaler is given the
+	annotations of the field holding the value.
+
+Errors:
+
+	Errors are returned as a *FieldError giving the path and offset of the
+	field that failed, wrapping one of ErrShortBuffer, ErrOverflow,
+	ErrReserved, ErrConstant, ErrChecksum, ErrUnknownCase or ErrTag where
+	applicable. ErrShortBuffer also matches io.EOF.
 */
 func Decode(reader io.ByteReader, s interface{}) error {
+	return DecodeWithOptions(reader, s, Options{})
+}
+
+// DecodeWithOptions is Decode with the defaults and checks given by opts.
+func DecodeWithOptions(reader io.ByteReader, s interface{}, opts Options) error {
 
 	d := decoder{
 		reader:      reader,
@@ -308,10 +739,26 @@ func Decode(reader io.ByteReader, s interface{}) error {
 		bitOffset:   0,
 	}
 
-	t := newTranscoder(&d)
+	return d.decode(s, opts)
+}
+
+// decode deserializes s continuing from the current position of the stream.
+func (d *decoder) decode(s interface{}, opts Options) error {
+	t, err := newTranscoder(d, opts)
+	if err != nil {
+		return err
+	}
 	d.transcoder = t
 
-	return t.transcode(reflect.ValueOf(s), nil)
+	if err := t.transcode(reflect.ValueOf(s), nil); err != nil {
+		return err
+	}
+
+	if opts.Strict && d.bitOffset != 0 {
+		return fmt.Errorf("Left-over bits in structure definition: %w", ErrTag)
+	}
+
+	return nil
 }
 
 // DecodeByteBuffer takes a raw byte buffer and unpacks the buffer into
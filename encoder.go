@@ -25,8 +25,6 @@ package structex
 import (
 	"fmt"
 	"io"
-	"math"
-	"math/bits"
 	"reflect"
 )
 
@@ -35,13 +33,16 @@ type encoder struct {
 	currentByte uint8
 	byteOffset  uint64
 	bitOffset   uint64
+	bitOrder    bitOrder // Bit order of the partially written byte
+	customOrder bitOrder // Bit order of the Marshaler being called
 	transcoder  *transcoder
 }
 
 func (e *encoder) write(value uint64, nbits uint64) error {
 
-	if nbits > 1 && value > math.MaxUint64 {
-		return fmt.Errorf("Value %d (%#x) will overflow bitfield of %d bits", value, value, nbits)
+	// Bits beyond the field must not spill into the fields that follow
+	if nbits < 64 {
+		value &= 1<<nbits - 1
 	}
 
 	// Write any bits that might be part of previous bitfield definitions
@@ -56,7 +57,9 @@ func (e *encoder) write(value uint64, nbits uint64) error {
 			value = value >> remainingBits
 			nbits -= remainingBits
 
-			e.writeByte(e.currentByte)
+			if err := e.writeByte(e.currentByte); err != nil {
+				return err
+			}
 			e.currentByte = uint8(value)
 			e.bitOffset = 0
 		}
@@ -68,7 +71,9 @@ func (e *encoder) write(value uint64, nbits uint64) error {
 			e.bitOffset += nbits
 			return nil
 		} else {
-			e.writeByte(e.currentByte)
+			if err := e.writeByte(e.currentByte); err != nil {
+				return err
+			}
 
 			value = value >> 8
 			nbits -= 8
@@ -78,6 +83,54 @@ func (e *encoder) write(value uint64, nbits uint64) error {
 	return nil
 }
 
+// writeMSB writes nbits of value starting with the most significant bit,
+// allocating bits from the high bit of each byte downwards.
+func (e *encoder) writeMSB(value uint64, nbits uint64) error {
+	if e.bitOffset == 0 {
+		e.currentByte = 0
+	}
+
+	for nbits != 0 {
+		n := 8 - e.bitOffset
+		if nbits < n {
+			n = nbits
+		}
+
+		bits := (value >> (nbits - n)) & (1<<n - 1)
+		e.currentByte |= uint8(bits << (8 - e.bitOffset - n))
+		e.bitOffset += n
+		nbits -= n
+
+		if e.bitOffset == 8 {
+			if err := e.writeByte(e.currentByte); err != nil {
+				return err
+			}
+			e.currentByte = 0
+			e.bitOffset = 0
+		}
+	}
+
+	return nil
+}
+
+// writeOrdered writes nbits of value in the provided bit order. Bit orders
+// cannot be mixed within a single byte.
+func (e *encoder) writeOrdered(value uint64, nbits uint64, order bitOrder) error {
+	if e.bitOffset != 0 && e.bitOrder != order {
+		return fmt.Errorf("Bit order changes within byte %d: %w", e.byteOffset, ErrTag)
+	}
+	e.bitOrder = order
+
+	if order == msbFirst {
+		return e.writeMSB(value, nbits)
+	}
+	return e.write(value, nbits)
+}
+
+func (e *encoder) position() (uint64, uint64) {
+	return e.byteOffset, e.bitOffset
+}
+
 func (e *encoder) writeByte(value uint8) error {
 	if err := e.writer.WriteByte(value); err != nil {
 		return err
@@ -104,62 +157,148 @@ func (e *encoder) align(val alignment) error {
 }
 
 func (e *encoder) field(val reflect.Value, tags *tags) error {
-	v := getValue(val)
+	if val.Kind() == reflect.String {
+		return e.writeString(val, tags)
+	}
 
-	nbits := tags.bitfield.nbits
+	var v uint64
+	switch val.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v = floatBits(val, tags)
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var err error
+		if v, err = getValue(val); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unsupported write type %s: %w", val.Kind().String(), ErrTag)
+	}
+
+	nbits := uint64(0)
+	if tags != nil {
+		nbits = tags.bitfield.nbits
+		if tags.constant.set {
+			v = tags.constant.value
+		}
+	}
 	if nbits == 0 {
 		nbits = uint64(val.Type().Bits())
 	}
 
-	if (tags != nil && tags.endian == big) || (e.transcoder.defaultEndianness == big && (tags != nil && tags.endian != little)) {
-		switch val.Kind() {
-		case reflect.Uint16, reflect.Int16:
-			v = uint64(bits.ReverseBytes16(uint16(v)))
-		case reflect.Uint32, reflect.Int32, reflect.Uint, reflect.Int:
-			v = uint64(bits.ReverseBytes32(uint32(v)))
-		case reflect.Uint64, reflect.Int64:
-			v = bits.ReverseBytes64(v)
+	var err error
+	if tags != nil && tags.bitfield.reserved {
+		if v, err = e.Reserved(v, nbits); err != nil {
+			return err
+		}
+	}
+
+	if val.Kind() != reflect.Float32 && val.Kind() != reflect.Float64 {
+		if v, err = e.Fit(v, nbits, isSigned(val.Kind())); err != nil {
+			return err
 		}
 	}
 
-	return e.write(v, nbits)
+	return e.writeValue(v, nbits, tags)
 }
 
-func (e *encoder) layout(val reflect.Value, ref *tagReference) error {
-	value := uint64(0)
+// writeValue writes nbits of value in the bit and byte order of a field
+// annotated with tags. Byte order only applies to fields wider than a byte.
+func (e *encoder) writeValue(value uint64, nbits uint64, tags *tags) error {
+	order := e.transcoder.bitOrder(tags)
 
-	if ref.value.IsZero() {
-		switch ref.tags.layout.format {
-		case sizeOf:
-			sz, err := size(val.Index(0))
-			if err != nil {
-				return err
-			}
+	// Writing least significant bit first is naturally little-endian, and
+	// most significant bit first naturally big-endian.
+	if nbits > 8 && e.transcoder.bigEndian(tags) != (order == msbFirst) {
+		return e.writeReversed(value, nbits, order)
+	}
 
-			value = uint64(val.Len()) * sz
-			if ref.tags.layout.relative {
-				value -= e.byteOffset
-			}
+	return e.writeOrdered(value, nbits, order)
+}
+
+// writeReversed writes nbits of value in the byte order opposite to that
+// natural for the bit order. The value is split into chunks, each filling
+// the remainder of the current byte; for big-endian the most significant
+// chunk is written first, for little-endian the least significant.
+func (e *encoder) writeReversed(value uint64, nbits uint64, order bitOrder) error {
+	for nbits != 0 {
+		n := 8 - e.bitOffset
+		if nbits < n {
+			n = nbits
+		}
+
+		var chunk uint64
+		if order == msbFirst {
+			chunk = value & (1<<n - 1)
+			value >>= n
+		} else {
+			chunk = (value >> (nbits - n)) & (1<<n - 1)
+		}
 
-		case countOf:
-			value = uint64(val.Len())
+		if err := e.writeOrdered(chunk, n, order); err != nil {
+			return err
 		}
-	} else {
-		value = getValue(ref.value)
+		nbits -= n
 	}
 
-	return e.write(value, ref.tags.bitfield.nbits)
+	return nil
+}
+
+func (e *encoder) writeString(val reflect.Value, tags *tags) error {
+	str := val.String()
+	if tags.constant.bytes != nil {
+		str = string(tags.constant.bytes)
+	}
+
+	b, err := stringBytes(str, tags)
+	if err != nil {
+		return err
+	}
+
+	order := e.transcoder.bitOrder(tags)
+	for _, c := range b {
+		if err := e.writeOrdered(uint64(c), 8, order); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *encoder) layout(val reflect.Value, ref *tagReference) error {
+	value, err := e.transcoder.layoutValue(val, ref, e.byteOffset, e.bitOffset)
+	if err != nil {
+		return err
+	}
+
+	value, err = e.Fit(value, ref.tags.bitfield.nbits, isSigned(ref.value.Kind()))
+	if err != nil {
+		return err
+	}
+	ref.layoutValue = value
+
+	return e.writeValue(value, ref.tags.bitfield.nbits, ref.tags)
 }
 
 func (e *encoder) array(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error {
-	l := arr.Len()
-	if ref != nil && !ref.value.IsZero() {
-		l = int(ref.value.Uint())
+	l := int(t.elements(arr, tags, ref, e.byteOffset))
+
+	// Constant byte arrays are written regardless of their contents
+	if tags != nil && tags.constant.bytes != nil {
+		for _, c := range tags.constant.bytes {
+			if err := e.writeValue(uint64(c), 8, tags); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
 	for i := 0; i < l; i++ {
+		offset, bit := e.position()
+
 		if err := t.transcode(arr.Index(i), tags); err != nil {
-			return err
+			return elementError(err, i, offset, bit)
 		}
 	}
 
@@ -167,6 +306,13 @@ func (e *encoder) array(t *transcoder, arr reflect.Value, tags *tags, ref *tagRe
 }
 
 func (e *encoder) slice(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error {
+	if tags != nil && tags.sentinel != nil {
+		var err error
+		if arr, err = tags.sentinel.terminated(arr); err != nil {
+			return err
+		}
+	}
+
 	return e.array(t, arr, tags, ref)
 }
 
@@ -174,8 +320,16 @@ func (e *encoder) slice(t *transcoder, arr reflect.Value, tags *tags, ref *tagRe
 Encode serializes the data structure defined by 's' into the available
 io.ByteWriter stream. Annotation rules are as defined in the Decode
 function.
+
+Values implementing Marshaler, such as structures with code generated by
+structexgen, encode themselves in place of reflection.
 */
 func Encode(writer io.ByteWriter, s interface{}) error {
+	return EncodeWithOptions(writer, s, Options{})
+}
+
+// EncodeWithOptions is Encode with the defaults given by opts.
+func EncodeWithOptions(writer io.ByteWriter, s interface{}, opts Options) error {
 
 	e := encoder{
 		writer:      writer,
@@ -184,7 +338,15 @@ func Encode(writer io.ByteWriter, s interface{}) error {
 		bitOffset:   0,
 	}
 
-	t := newTranscoder(&e)
+	return e.encode(s, opts)
+}
+
+// encode serializes s continuing from the current position of the stream.
+func (e *encoder) encode(s interface{}, opts Options) error {
+	t, err := newTranscoder(e, opts)
+	if err != nil {
+		return err
+	}
 	e.transcoder = t
 
 	return t.transcode(reflect.ValueOf(s), nil)
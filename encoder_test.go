@@ -24,7 +24,9 @@ USE OR OTHER DEALINGS IN THE SOFTWARE.
 package structex
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"testing"
@@ -183,7 +185,7 @@ func TestBitfieldEncoder(t *testing.T) {
 		D int `bitfield:"12"`
 		E int `bitfield:"4"`
 	}{
-		0x7, 0x8, 0x1, 0x0FFF, 0x1,
+		-1, -8, -1, -1, 0x1,
 	}
 
 	packAndTest(t, s, func(t *testing.T, tw *testWriter) {
@@ -471,3 +473,118 @@ func TestSignedEncoder(t *testing.T) {
 		}
 	})
 }
+
+type bigBitfields struct {
+	Type   uint8  `bitfield:"4"`
+	Length uint16 `bitfield:"12" big:""`
+	LBA    uint32 `bitfield:"24" big:""`
+	Count  uint64 `bitfield:"48" big:""`
+	Small  uint8  `bitfield:"6" big:""`
+	Rest   uint16 `bitfield:"10" big:""`
+	N      uint16 `countOf:"D" big:""`
+	D      []uint8
+}
+
+func TestBigEndianBitfields(t *testing.T) {
+	s := bigBitfields{
+		Type:   0xA,
+		Length: 0xBCD,
+		LBA:    0x123456,
+		Count:  0x0123456789AB,
+		Small:  0x2A,
+		Rest:   0x3C5,
+		N:      2,
+		D:      []uint8{7, 8},
+	}
+
+	encoded := []byte{
+		0xBA, 0xCD,
+		0x12, 0x34, 0x56,
+		0x01, 0x23, 0x45, 0x67, 0x89, 0xAB,
+		0xEA, 0xC5,
+		0x00, 0x02,
+		0x07, 0x08,
+	}
+
+	packAndTest(t, s, func(t *testing.T, tw *testWriter) {
+		for i, b := range encoded {
+			if tw.getByte(i) != b {
+				t.Errorf("Invalid byte %d: Expected: %#02x Actual: %#02x", i, b, tw.getByte(i))
+			}
+		}
+	})
+
+	unpackAndTest(t, new(bigBitfields), newReader(encoded), func(t *testing.T, i interface{}) {
+		if actual := i.(*bigBitfields); fmt.Sprint(*actual) != fmt.Sprint(s) {
+			t.Errorf("Invalid decoding:\nExpected: %+v\nActual:   %+v", s, *actual)
+		}
+	})
+
+	sz, err := Size(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sz != uint64(len(encoded)) {
+		t.Errorf("Invalid size: Expected: %d Actual: %d", len(encoded), sz)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	type ts struct {
+		A uint8 `bitfield:"5"`
+		B int8  `bitfield:"4"`
+		C uint8 `bitfield:"7"`
+	}
+
+	tests := []struct {
+		s        ts
+		mode     OverflowMode
+		expected []byte
+		path     string
+	}{
+		{ts{A: 31, B: -8, C: 0x7F}, OverflowError, []byte{0x1F, 0xFF}, ""},
+		{ts{A: 40, B: 0, C: 0}, OverflowError, nil, "A"},
+		{ts{A: 0, B: 8, C: 0}, OverflowError, nil, "B"},
+		{ts{A: 0, B: -9, C: 0}, OverflowError, nil, "B"},
+		{ts{A: 40, B: -9, C: 0}, OverflowTruncate, []byte{0xE8, 0x00}, ""},
+		{ts{A: 40, B: -9, C: 0}, OverflowSaturate, []byte{0x1F, 0x01}, ""},
+		{ts{A: 0, B: 9, C: 0xFF}, OverflowSaturate, []byte{0xE0, 0xFE}, ""},
+	}
+
+	for _, test := range tests {
+		var b bytes.Buffer
+		err := EncodeWithOptions(&b, test.s, Options{Overflow: test.mode})
+
+		if len(test.path) != 0 {
+			var fe *FieldError
+			if !errors.Is(err, ErrOverflow) || !errors.As(err, &fe) || fe.Path != test.path {
+				t.Errorf("Expected overflow of field %s for %+v: Actual: %v", test.path, test.s, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Encode of %+v failed: %v", test.s, err)
+			continue
+		}
+
+		if !bytes.Equal(b.Bytes(), test.expected) {
+			t.Errorf("Unexpected encoding of %+v: Expected: %#v Actual: %#v", test.s, test.expected, b.Bytes())
+		}
+	}
+}
+
+func TestOverflowLayout(t *testing.T) {
+	s := struct {
+		Count uint8 `countOf:"Items" bitfield:"4"`
+		Pad   uint8 `bitfield:"4"`
+		Items []uint8
+	}{
+		Items: make([]uint8, 16),
+	}
+
+	var fe *FieldError
+	if err := Encode(new(bytes.Buffer), s); !errors.As(err, &fe) || fe.Path != "Count" || !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow of field Count: Actual: %v", err)
+	}
+}
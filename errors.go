@@ -0,0 +1,117 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sentinel errors reported by Encode, Decode and Size. They are usually
+// wrapped in a FieldError and should be tested for with errors.Is.
+var (
+	// ErrShortBuffer reports the data ended before the structure did.
+	// For compatibility with earlier releases it also matches io.EOF.
+	ErrShortBuffer error = shortBufferError{}
+
+	// ErrOverflow reports a value that does not fit its field.
+	ErrOverflow = errors.New("structex: value overflows field")
+
+	// ErrReserved reports non-zero reserved bits.
+	ErrReserved = errors.New("structex: reserved bits are not zero")
+
+	// ErrConstant reports a field that does not hold its constant.
+	ErrConstant = errors.New("structex: constant does not match")
+
+	// ErrChecksum reports a checksum field that does not match the data.
+	ErrChecksum = errors.New("structex: checksum does not match")
+
+	// ErrUnknownCase reports a union whose discriminator or concrete type
+	// has no case registered with RegisterCase.
+	ErrUnknownCase = errors.New("structex: no case registered for union")
+
+	// ErrTag reports an annotation that cannot be applied to its field.
+	ErrTag = errors.New("structex: invalid tag")
+)
+
+type shortBufferError struct{}
+
+func (shortBufferError) Error() string {
+	return "structex: short buffer"
+}
+
+func (shortBufferError) Is(target error) bool {
+	return target == io.EOF
+}
+
+// A FieldError describes an error transcoding a structure field.
+type FieldError struct {
+	// Path of the field within the top level structure, i.e.
+	// Descriptors[3].Length
+	Path string
+
+	// Offset is the byte offset of the field from the start of the
+	// stream, and Bit the offset in bits of the field within that byte.
+	Offset uint64
+	Bit    uint64
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s (byte %d, bit %d): %v", e.Path, e.Offset, e.Bit, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError annotates err with the field name, or the [index] of an array
+// or slice element, at which it occurred. Errors are annotated as they
+// return through each enclosing field, building the path from the inside
+// out, while the offset is that of the innermost field.
+func fieldError(err error, name string, offset uint64, bit uint64) error {
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		if strings.HasPrefix(fe.Path, "[") {
+			fe.Path = name + fe.Path
+		} else {
+			fe.Path = name + "." + fe.Path
+		}
+		return err
+	}
+
+	if err == io.EOF {
+		err = ErrShortBuffer
+	}
+
+	return &FieldError{Path: name, Offset: offset, Bit: bit, Err: err}
+}
+
+// elementError annotates err with the index of an array or slice element.
+func elementError(err error, index int, offset uint64, bit uint64) error {
+	return fieldError(err, fmt.Sprintf("[%d]", index), offset, bit)
+}
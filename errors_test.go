@@ -0,0 +1,171 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type errorDescriptor struct {
+	Type   uint8
+	Length uint16
+}
+
+type errorIdentify struct {
+	Version     uint8 `bitfield:"4"`
+	Flags       uint8 `bitfield:"4"`
+	Count       uint8 `countOf:"Descriptors"`
+	Descriptors []errorDescriptor
+}
+
+func TestFieldErrorDecode(t *testing.T) {
+	b := []byte{0x21, 4, 1, 0, 1, 2, 0, 2, 3, 0, 3, 4, 0}
+
+	var s errorIdentify
+	err := Decode(bytes.NewReader(b), &s)
+
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Expected FieldError: Actual: %v", err)
+	}
+
+	if fe.Path != "Descriptors[3].Length" || fe.Offset != 12 || fe.Bit != 0 {
+		t.Errorf("Unexpected field error: Path: %s Offset: %d Bit: %d", fe.Path, fe.Offset, fe.Bit)
+	}
+
+	if !errors.Is(err, ErrShortBuffer) || !errors.Is(err, io.EOF) {
+		t.Errorf("Expected short buffer error: Actual: %v", err)
+	}
+}
+
+func TestFieldErrorBitOffset(t *testing.T) {
+	var s struct {
+		A uint8 `bitfield:"4"`
+		B uint16
+	}
+
+	err := Decode(bytes.NewReader([]byte{0x01, 0x02}), &s)
+
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Expected FieldError: Actual: %v", err)
+	}
+
+	if fe.Path != "B" || fe.Offset != 0 || fe.Bit != 4 {
+		t.Errorf("Unexpected field error: Path: %s Offset: %d Bit: %d", fe.Path, fe.Offset, fe.Bit)
+	}
+}
+
+func TestFieldErrorEncode(t *testing.T) {
+	type inner struct {
+		Pad  uint16
+		Name string `string:"2"`
+	}
+
+	s := struct {
+		Header uint8
+		Inner  inner
+	}{
+		Inner: inner{Name: "ABC"},
+	}
+
+	err := Encode(NewBuffer(s), s)
+
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Expected FieldError: Actual: %v", err)
+	}
+
+	if fe.Path != "Inner.Name" || fe.Offset != 3 || fe.Bit != 0 {
+		t.Errorf("Unexpected field error: Path: %s Offset: %d Bit: %d", fe.Path, fe.Offset, fe.Bit)
+	}
+
+	if !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow error: Actual: %v", err)
+	}
+}
+
+func TestFieldErrorShortWrite(t *testing.T) {
+	s := struct {
+		Header uint8
+		Low    uint8 `bitfield:"4"`
+		High   uint8 `bitfield:"4"`
+		Word   uint16
+	}{}
+
+	// Writes ending on a byte boundary fail when the buffer is full
+	err := Encode(NewBuffer(struct{ A uint8 }{}), s)
+
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Path != "High" || !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected short buffer writing High: Actual: %v", err)
+	}
+}
+
+func TestFieldErrorTag(t *testing.T) {
+	var s struct {
+		A uint8 `bitfield:"x"`
+	}
+
+	if _, err := Size(s); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected tag error: Actual: %v", err)
+	}
+
+	var u struct {
+		A uint8
+		B complex64
+	}
+
+	// Unsupported types are found before any field is encoded
+	err := Encode(bytes.NewBuffer(nil), u)
+
+	var terr *TaggingError
+	if !errors.As(err, &terr) || !strings.HasPrefix(err.Error(), ".B: ") {
+		t.Errorf("Expected tag error for field B: Actual: %v", err)
+	}
+}
+
+func TestFieldErrorCustom(t *testing.T) {
+	// Values decoding themselves at the top level are reported by type
+	err := Decode(bytes.NewReader([]byte{0x03, 0x01}), new(handSlice))
+
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Path != "handSlice" || fe.Offset != 0 || !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected short buffer error for handSlice: Actual: %v", err)
+	}
+
+	// Reserved fields are reported by name, within the enclosing field
+	var s struct {
+		Pad  uint8
+		Data handReserved
+	}
+	err = DecodeWithOptions(bytes.NewReader([]byte{0x00, 0x05}), &s, Options{Strict: true})
+	if !errors.As(err, &fe) || fe.Path != "Data.Rsvd" || fe.Offset != 1 || fe.Bit != 2 || !errors.Is(err, ErrReserved) {
+		t.Errorf("Expected reserved error for Data.Rsvd: Actual: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"math"
+	"reflect"
+)
+
+type floatFormat int
+
+const (
+	floatNative floatFormat = 0 // IEEE 754 format of the Go type
+	floatHalf   floatFormat = 1 // IEEE 754 binary16
+	floatBrain  floatFormat = 2 // bfloat16, the upper half of a binary32
+)
+
+// floatBits returns the bits of a floating-point value in the wire format
+// defined by the field tags.
+func floatBits(val reflect.Value, tags *tags) uint64 {
+	format := floatNative
+	if tags != nil {
+		format = tags.float
+	}
+
+	switch format {
+	case floatHalf:
+		return uint64(float16bits(float32(val.Float())))
+	case floatBrain:
+		return uint64(bfloat16bits(float32(val.Float())))
+	}
+
+	if val.Kind() == reflect.Float32 {
+		return uint64(math.Float32bits(float32(val.Float())))
+	}
+	return math.Float64bits(val.Float())
+}
+
+// floatFromBits returns the floating-point value of bits in the wire format
+// defined by the field tags.
+func floatFromBits(v uint64, kind reflect.Kind, tags *tags) float64 {
+	format := floatNative
+	if tags != nil {
+		format = tags.float
+	}
+
+	switch format {
+	case floatHalf:
+		return float64(float16frombits(uint16(v)))
+	case floatBrain:
+		return float64(bfloat16frombits(uint16(v)))
+	}
+
+	if kind == reflect.Float32 {
+		return float64(math.Float32frombits(uint32(v)))
+	}
+	return math.Float64frombits(v)
+}
+
+// float16bits converts f to IEEE 754 binary16, rounding to nearest even.
+// Values too large for binary16 become infinities.
+func float16bits(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int32(b>>23) & 0xFF
+	mant := b & 0x7FFFFF
+
+	// Infinity and NaN; NaNs are kept quiet.
+	if exp == 0xFF {
+		if mant != 0 {
+			return sign | 0x7E00 | uint16(mant>>13)
+		}
+		return sign | 0x7C00
+	}
+
+	e := exp - 127 + 15
+	if e >= 0x1F {
+		return sign | 0x7C00
+	}
+
+	// Subnormal binary16, or too small to represent.
+	if e <= 0 {
+		if e < -10 {
+			return sign
+		}
+
+		mant |= 0x800000
+		shift := uint32(14 - e)
+		h := mant >> shift
+		rem := mant & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if rem > halfway || (rem == halfway && h&1 == 1) {
+			h++
+		}
+		return sign | uint16(h)
+	}
+
+	// Rounding may carry into the exponent, which is the correct result
+	// including the overflow to infinity.
+	h := uint32(e)<<10 | mant>>13
+	rem := mant & 0x1FFF
+	if rem > 0x1000 || (rem == 0x1000 && h&1 == 1) {
+		h++
+	}
+	return sign | uint16(h)
+}
+
+// float16frombits converts IEEE 754 binary16 to float32. The conversion is
+// exact.
+func float16frombits(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1F
+	mant := uint32(h & 0x3FF)
+
+	switch exp {
+	case 0x1F:
+		return math.Float32frombits(sign | 0x7F800000 | mant<<13)
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+
+		// Normalize the subnormal value
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		mant &= 0x3FF
+		return math.Float32frombits(sign | e<<23 | mant<<13)
+	}
+
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// bfloat16bits converts f to bfloat16, rounding to nearest even.
+func bfloat16bits(f float32) uint16 {
+	b := math.Float32bits(f)
+	if b&0x7FFFFFFF > 0x7F800000 {
+		return uint16(b>>16) | 0x40
+	}
+
+	b += 0x7FFF + (b>>16)&1
+	return uint16(b >> 16)
+}
+
+// bfloat16frombits converts bfloat16 to float32. The conversion is exact.
+func bfloat16frombits(h uint16) float32 {
+	return math.Float32frombits(uint32(h) << 16)
+}
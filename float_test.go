@@ -0,0 +1,183 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestFloat16Bits(t *testing.T) {
+	tests := []struct {
+		f float32
+		h uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3C00},
+		{-2, 0xC000},
+		{65504, 0x7BFF},
+		{65520, 0x7C00},
+		{6.1035156e-05, 0x0400},
+		{5.9604645e-08, 0x0001},
+		{2.9802322e-08, 0x0000},
+		{1.0 / 3.0, 0x3555},
+		{float32(math.Inf(1)), 0x7C00},
+		{float32(math.Inf(-1)), 0xFC00},
+	}
+
+	for _, test := range tests {
+		if h := float16bits(test.f); h != test.h {
+			t.Errorf("Invalid binary16 of %g: Expected: %#04x Actual: %#04x", test.f, test.h, h)
+		}
+	}
+
+	if h := float16bits(float32(math.NaN())); h&0x7C00 != 0x7C00 || h&0x3FF == 0 {
+		t.Errorf("Invalid binary16 NaN: %#04x", h)
+	}
+}
+
+func TestFloat16RoundTrip(t *testing.T) {
+	for i := 0; i <= math.MaxUint16; i++ {
+		h := uint16(i)
+		if h&0x7C00 == 0x7C00 && h&0x3FF != 0 {
+			continue // NaN
+		}
+
+		if r := float16bits(float16frombits(h)); r != h {
+			t.Errorf("Invalid binary16 round trip: Expected: %#04x Actual: %#04x", h, r)
+		}
+	}
+}
+
+func TestBFloat16Bits(t *testing.T) {
+	tests := []struct {
+		f float32
+		h uint16
+	}{
+		{0, 0x0000},
+		{1, 0x3F80},
+		{-2, 0xC000},
+		{math.Pi, 0x4049},
+		{1.00390625, 0x3F80}, // Tie, rounds to even
+		{1.01171875, 0x3F82}, // Tie, rounds to even
+		{float32(math.Inf(1)), 0x7F80},
+	}
+
+	for _, test := range tests {
+		if h := bfloat16bits(test.f); h != test.h {
+			t.Errorf("Invalid bfloat16 of %g: Expected: %#04x Actual: %#04x", test.f, test.h, h)
+		}
+		if f := bfloat16frombits(test.h); float16bits(f) != float16bits(bfloat16frombits(bfloat16bits(test.f))) {
+			t.Errorf("Invalid bfloat16 conversion of %#04x: %g", test.h, f)
+		}
+	}
+}
+
+type floatStruct struct {
+	F32   float32
+	F64   float64    `big:""`
+	Half  float32    `float:"half"`
+	BHalf float64    `float:"half" big:""`
+	Brain float32    `float:"bfloat16"`
+	Arr   [2]float32 `float:"half"`
+}
+
+var floatBytes = []byte{
+	0x00, 0x00, 0x80, 0x3F,
+	0x40, 0x09, 0x21, 0xFB, 0x54, 0x44, 0x2D, 0x18,
+	0x00, 0x3C,
+	0xC0, 0x00,
+	0x49, 0x40,
+	0x00, 0x38, 0x00, 0xB8,
+}
+
+func TestFloatEncoder(t *testing.T) {
+	s := floatStruct{
+		F32:   1,
+		F64:   math.Pi,
+		Half:  1,
+		BHalf: -2,
+		Brain: math.Pi,
+		Arr:   [2]float32{0.5, -0.5},
+	}
+
+	packAndTest(t, s, func(t *testing.T, tw *testWriter) {
+		if actual := tw.getBytes(0, tw.getSize()-1); !bytes.Equal(actual, floatBytes) {
+			t.Errorf("Invalid float encoding:\nExpected: %x\nActual:   %x", floatBytes, actual)
+		}
+	})
+
+	sz, err := Size(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sz != uint64(len(floatBytes)) {
+		t.Errorf("Invalid float structure size: Expected: %d Actual: %d", len(floatBytes), sz)
+	}
+}
+
+func TestFloatDecoder(t *testing.T) {
+	unpackAndTest(t, new(floatStruct), newReader(floatBytes), func(t *testing.T, i interface{}) {
+		s := i.(*floatStruct)
+
+		if s.F32 != 1 {
+			t.Errorf("Invalid float32: Expected: %g Actual: %g", 1.0, s.F32)
+		}
+		if s.F64 != math.Pi {
+			t.Errorf("Invalid big-endian float64: Expected: %g Actual: %g", math.Pi, s.F64)
+		}
+		if s.Half != 1 {
+			t.Errorf("Invalid half: Expected: %g Actual: %g", 1.0, s.Half)
+		}
+		if s.BHalf != -2 {
+			t.Errorf("Invalid big-endian half: Expected: %g Actual: %g", -2.0, s.BHalf)
+		}
+		if s.Brain != 3.140625 {
+			t.Errorf("Invalid bfloat16: Expected: %g Actual: %g", 3.140625, s.Brain)
+		}
+		if s.Arr[0] != 0.5 || s.Arr[1] != -0.5 {
+			t.Errorf("Invalid half array: %v", s.Arr)
+		}
+	})
+}
+
+func TestFloatTaggingError(t *testing.T) {
+	type bitfieldFloat struct {
+		F float32 `bitfield:"8"`
+	}
+	type unknownFormat struct {
+		F float32 `float:"quarter"`
+	}
+	type intFormat struct {
+		I uint16 `float:"half"`
+	}
+
+	for _, s := range []interface{}{bitfieldFloat{}, unknownFormat{}, intFormat{}} {
+		if _, err := Size(s); err == nil {
+			t.Errorf("Expected tagging error for %T", s)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+)
+
+/*
+CheckGenerated cross-checks the generated Marshaler and Unmarshaler of the
+structure pointed to by 's' against the reflective Encode and Decode paths.
+The structure is filled with pseudo-random values that fit the annotated
+bitfields, encoded both ways and the resulting bytes compared; the bytes are
+then decoded both ways and the resulting structures compared. Alternate
+rounds transcode most significant bit first.
+
+Values of the types pointed to by 'nested', usually the other structures
+generated along with s, are filled and reflected like s itself, so their
+generated code is checked wherever s holds them. Values of any other type
+transcoding itself are left zero and called both ways.
+
+CheckGenerated is used by the tests that structexgen emits with -test.
+*/
+func CheckGenerated(s interface{}, nested ...interface{}) error {
+	val := reflect.ValueOf(s)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("CheckGenerated requires a structure pointer; have %s", val.Type().String())
+	}
+
+	if _, ok := s.(Marshaler); !ok {
+		return fmt.Errorf("%s does not implement Marshaler", val.Type().String())
+	}
+	if _, ok := s.(Unmarshaler); !ok {
+		return fmt.Errorf("%s does not implement Unmarshaler", val.Type().String())
+	}
+
+	generated := map[reflect.Type]bool{val.Elem().Type(): true}
+	for _, n := range nested {
+		typ := reflect.TypeOf(n)
+		for typ != nil && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == nil || typ.Kind() != reflect.Struct {
+			return fmt.Errorf("CheckGenerated requires structure pointers; have %T", n)
+		}
+		generated[typ] = true
+	}
+
+	f := filler{rnd: rand.New(rand.NewSource(1)), generated: generated}
+
+	for round := 0; round < 64; round++ {
+		val.Elem().Set(reflect.Zero(val.Elem().Type()))
+		// Some rounds preset the layout fields the encoders otherwise
+		// compute, exercising how each limits the elements encoded.
+		f.layouts = round%4 == 3
+		if err := f.fillStruct(val.Elem()); err != nil {
+			return err
+		}
+
+		var opts Options
+		if round%2 == 1 {
+			opts.BitOrder = MSBFirst
+		}
+
+		genBuf, refBuf := new(bytes.Buffer), new(bytes.Buffer)
+
+		genErr := encodeValue(genBuf, val, opts, nil)
+		refErr := encodeValue(refBuf, val, opts, generated)
+		if (genErr == nil) != (refErr == nil) {
+			return fmt.Errorf("round %d: encode errors differ: generated: %v reflective: %v", round, genErr, refErr)
+		}
+		if genErr != nil {
+			continue
+		}
+		if !bytes.Equal(genBuf.Bytes(), refBuf.Bytes()) {
+			return fmt.Errorf("round %d: encoded bytes differ:\ngenerated:  %x\nreflective: %x", round, genBuf.Bytes(), refBuf.Bytes())
+		}
+
+		genVal := reflect.New(val.Elem().Type())
+		refVal := reflect.New(val.Elem().Type())
+
+		genErr = decodeValue(bytes.NewBuffer(genBuf.Bytes()), genVal, opts, nil)
+		refErr = decodeValue(bytes.NewBuffer(refBuf.Bytes()), refVal, opts, generated)
+		if (genErr == nil) != (refErr == nil) {
+			return fmt.Errorf("round %d: decode errors differ: generated: %v reflective: %v", round, genErr, refErr)
+		}
+		if !reflect.DeepEqual(genVal.Interface(), refVal.Interface()) {
+			return fmt.Errorf("round %d: decoded structures differ:\ngenerated:  %+v\nreflective: %+v", round, genVal.Elem().Interface(), refVal.Elem().Interface())
+		}
+	}
+
+	return nil
+}
+
+// encodeValue encodes val with its generated Marshaler, or by reflecting the
+// types of reflected if not nil.
+func encodeValue(buf *bytes.Buffer, val reflect.Value, opts Options, reflected map[reflect.Type]bool) error {
+	e := encoder{writer: buf}
+	t, err := newTranscoder(&e, opts)
+	if err != nil {
+		return err
+	}
+	e.transcoder = t
+
+	if reflected == nil {
+		return e.custom(val.Elem(), nil)
+	}
+	t.reflected = reflected
+	return t.transcode(val, nil)
+}
+
+// decodeValue decodes val with its generated Unmarshaler, or by reflecting
+// the types of reflected if not nil.
+func decodeValue(buf *bytes.Buffer, val reflect.Value, opts Options, reflected map[reflect.Type]bool) error {
+	d := decoder{reader: buf}
+	t, err := newTranscoder(&d, opts)
+	if err != nil {
+		return err
+	}
+	d.transcoder = t
+
+	if reflected == nil {
+		return d.custom(val.Elem(), nil)
+	}
+	t.reflected = reflected
+	return t.transcode(val, nil)
+}
+
+// A filler sets values to pseudo-random contents that fit their annotated
+// bitfields.
+type filler struct {
+	rnd       *rand.Rand
+	layouts   bool                  // Layout fields are set, not left to the encoders
+	generated map[reflect.Type]bool // Types filled despite transcoding themselves
+}
+
+// fill sets val to a pseudo-random value that fits within its annotated
+// bitfield. Fields describing the layout of another field are left zero so
+// the encoders compute them, unless layouts is set.
+func (f *filler) fill(val reflect.Value, tags *tags) error {
+	// Values transcoding themselves are left zero, unless generated; only
+	// they know what contents are valid.
+	if isCustom(val.Type()) && !f.generated[val.Type()] {
+		return nil
+	}
+
+	switch val.Kind() {
+	case reflect.Struct:
+		return f.fillStruct(val)
+
+	case reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if err := f.fill(val.Index(i), tags); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		n := f.rnd.Intn(4)
+		val.Set(reflect.MakeSlice(val.Type(), n, n))
+		for i := 0; i < n; i++ {
+			if err := f.fill(val.Index(i), tags); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Ptr:
+		val.Set(reflect.New(val.Type().Elem()))
+		return f.fill(val.Elem(), tags)
+
+	case reflect.Interface:
+		// Unions hold one of their registered cases, if any
+		cases := casesOf(val.Type())
+		if len(cases) == 0 {
+			return nil
+		}
+
+		c := cases[f.rnd.Intn(len(cases))]
+		ptr := reflect.New(caseStruct(c.typ))
+		if err := f.fill(ptr.Elem(), nil); err != nil {
+			return err
+		}
+		if c.typ.Kind() == reflect.Ptr {
+			val.Set(ptr)
+		} else {
+			val.Set(ptr.Elem())
+		}
+
+	case reflect.String:
+		if tags == nil || tags.str.width == 0 {
+			return nil
+		}
+
+		// Letters never match the padding, and leave room for a NUL
+		b := make([]byte, f.rnd.Intn(int(tags.str.width)))
+		for i := range b {
+			b[i] = byte('a' + f.rnd.Intn(26))
+		}
+		val.SetString(string(b))
+
+	case reflect.Bool:
+		val.SetBool(f.rnd.Intn(2) == 1)
+
+	case reflect.Float32, reflect.Float64:
+		val.SetFloat(f.rnd.NormFloat64() * 1000)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val.SetUint(f.rnd.Uint64() & mask(fieldBits(val, tags)))
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		nbits := fieldBits(val, tags)
+		v := f.rnd.Uint64() & mask(nbits)
+		val.SetInt(int64(v<<(64-nbits)) >> (64 - nbits))
+
+	default:
+		return fmt.Errorf("Field type %s unsupported", val.Kind().String())
+	}
+
+	return nil
+}
+
+func (f *filler) fillStruct(val reflect.Value) error {
+	plan, err := planOf(val.Type())
+	if err != nil {
+		return err
+	}
+
+	for i := range plan.fields {
+		fp := &plan.fields[i]
+		if fp.tags.layout.format != none {
+			// Small values, so some fall short of the elements held
+			if f.layouts {
+				setBits(val.Field(fp.index), f.rnd.Uint64()%8&mask(fieldBits(val.Field(fp.index), &fp.tags)))
+			}
+			continue
+		}
+		if err := f.fill(val.Field(fp.index), &fp.tags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fieldBits(val reflect.Value, tags *tags) uint64 {
+	if tags != nil && tags.bitfield.nbits != 0 {
+		return tags.bitfield.nbits
+	}
+	return uint64(val.Type().Bits())
+}
+
+func mask(nbits uint64) uint64 {
+	if nbits >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << nbits) - 1
+}
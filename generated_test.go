@@ -0,0 +1,282 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type handWritten struct {
+	A uint8 `bitfield:"4"`
+	B uint8 `bitfield:"4"`
+}
+
+func (s handWritten) MarshalStructex(w BitWriter, f Field) error {
+	if err := w.WriteBits(uint64(s.A), 4); err != nil {
+		return err
+	}
+	return w.WriteBits(uint64(s.B), 4)
+}
+
+func (s *handWritten) UnmarshalStructex(r BitReader, f Field) error {
+	a, err := r.ReadBits(4)
+	if err != nil {
+		return err
+	}
+	b, err := r.ReadBits(4)
+	if err != nil {
+		return err
+	}
+	s.A, s.B = uint8(a), uint8(b)
+	return nil
+}
+
+type badHandWritten struct {
+	A uint8 `bitfield:"4"`
+	B uint8 `bitfield:"4"`
+}
+
+func (s badHandWritten) MarshalStructex(w BitWriter, f Field) error {
+	return w.WriteBits(uint64(s.B)|uint64(s.A)<<4, 8)
+}
+
+func (s *badHandWritten) UnmarshalStructex(r BitReader, f Field) error {
+	v, err := r.ReadBits(8)
+	s.A, s.B = uint8(v>>4), uint8(v&0xF)
+	return err
+}
+
+func TestGeneratedDispatch(t *testing.T) {
+	s := handWritten{A: 0x1, B: 0x2}
+
+	packAndTest(t, s, func(t *testing.T, tw *testWriter) {
+		if tw.getByte(0) != 0x21 {
+			t.Errorf("Invalid marshaled byte: Expected: %#02x Actual: %#02x", 0x21, tw.getByte(0))
+		}
+	})
+
+	d := new(handWritten)
+	if err := DecodeByteBuffer(bytes.NewBuffer([]byte{0x43}), d); err != nil {
+		t.Fatal(err)
+	}
+	if d.A != 0x3 || d.B != 0x4 {
+		t.Errorf("Invalid unmarshaled structure: %+v", d)
+	}
+}
+
+func TestCheckGenerated(t *testing.T) {
+	if err := CheckGenerated(new(handWritten)); err != nil {
+		t.Errorf("Unexpected cross-check failure: %v", err)
+	}
+
+	if err := CheckGenerated(new(badHandWritten)); err == nil {
+		t.Errorf("Expected cross-check failure")
+	}
+
+	if err := CheckGenerated(handWritten{}); err == nil {
+		t.Errorf("Expected error for non-pointer")
+	}
+}
+
+type handReserved struct {
+	A    uint8 `bitfield:"2"`
+	Rsvd uint8 `bitfield:"6,reserved"`
+}
+
+func (s *handReserved) UnmarshalStructex(r BitReader, f Field) error {
+	a, err := r.ReadBits(2)
+	if err != nil {
+		return err
+	}
+	v, err := r.ReadBits(6)
+	if err != nil {
+		return err
+	}
+	if err := r.Reserved("Rsvd", v, 6); err != nil {
+		return err
+	}
+	s.A, s.Rsvd = uint8(a), uint8(v)
+	return nil
+}
+
+func TestGeneratedReserved(t *testing.T) {
+	b := []byte{0x00, 0x05}
+
+	type reflected struct {
+		Pad  uint8
+		Data struct {
+			A    uint8 `bitfield:"2"`
+			Rsvd uint8 `bitfield:"6,reserved"`
+		}
+	}
+	type custom struct {
+		Pad  uint8
+		Data handReserved
+	}
+
+	// Reserved bits are checked alike by custom and reflective decoding
+	var expected, actual Report
+	if err := DecodeWithOptions(bytes.NewReader(b), new(reflected), Options{Report: &expected}); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeWithOptions(bytes.NewReader(b), new(custom), Options{Report: &actual}); err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.Reserved) != 1 || !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Invalid reserved report:\nExpected: %+v\nActual:   %+v", expected, actual)
+	}
+
+	if err := DecodeWithOptions(bytes.NewReader(b), new(custom), Options{Strict: true}); !errors.Is(err, ErrReserved) {
+		t.Errorf("Expected reserved error: Actual: %v", err)
+	}
+}
+
+type handSlice struct {
+	Count uint8 `countOf:"Data"`
+	Data  []uint8
+}
+
+func (s *handSlice) UnmarshalStructex(r BitReader, f Field) error {
+	n, err := r.ReadBits(8)
+	if err != nil {
+		return err
+	}
+	if err := r.SliceLen(n); err != nil {
+		return err
+	}
+	s.Count, s.Data = uint8(n), make([]uint8, n)
+	for i := range s.Data {
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		s.Data[i] = uint8(v)
+	}
+	return nil
+}
+
+func TestGeneratedSliceLen(t *testing.T) {
+	b := []byte{0x03, 0x01, 0x02, 0x03}
+
+	// Slice lengths are limited alike by custom and reflective decoding
+	opts := Options{MaxSliceLen: 2}
+	for _, v := range []interface{}{new(handSlice), new(struct {
+		Count uint8 `countOf:"Data"`
+		Data  []uint8
+	})} {
+		if err := DecodeWithOptions(bytes.NewReader(b), v, opts); !errors.Is(err, ErrOverflow) {
+			t.Errorf("Expected overflow error for %T: Actual: %v", v, err)
+		}
+	}
+
+	opts.MaxSliceLen = 3
+	s := new(handSlice)
+	if err := DecodeWithOptions(bytes.NewReader(b), s, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Data, []uint8{1, 2, 3}) {
+		t.Errorf("Invalid data: Expected: [1 2 3] Actual: %v", s.Data)
+	}
+}
+
+// handOuter holds values of the kinds CheckGenerated fills, and a nested
+// structure marshaled by hand.
+type handOuter struct {
+	Name  string `string:"4"`
+	Count *uint8
+	Inner badHandWritten
+}
+
+func (s handOuter) MarshalStructex(w BitWriter, f Field) error {
+	b := make([]byte, 4)
+	copy(b, s.Name)
+	for _, v := range b {
+		if err := w.WriteBits(uint64(v), 8); err != nil {
+			return err
+		}
+	}
+
+	if err := w.WriteBits(uint64(*s.Count), 8); err != nil {
+		return err
+	}
+	return s.Inner.MarshalStructex(w, Field{})
+}
+
+func (s *handOuter) UnmarshalStructex(r BitReader, f Field) error {
+	b := make([]byte, 4)
+	for i := range b {
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		b[i] = byte(v)
+	}
+	s.Name = string(bytes.TrimRight(b, "\x00"))
+
+	v, err := r.ReadBits(8)
+	if err != nil {
+		return err
+	}
+	s.Count = new(uint8)
+	*s.Count = uint8(v)
+
+	return s.Inner.UnmarshalStructex(r, Field{})
+}
+
+func TestCheckGeneratedNested(t *testing.T) {
+	// Nested values transcoding themselves are called both ways...
+	if err := CheckGenerated(new(handOuter)); err != nil {
+		t.Errorf("Unexpected cross-check failure: %v", err)
+	}
+
+	// ...unless also generated, when they are checked against reflection
+	if err := CheckGenerated(new(handOuter), new(badHandWritten)); err == nil {
+		t.Errorf("Expected cross-check failure of nested structure")
+	}
+
+	if err := CheckGenerated(new(handOuter), uint8(0)); err == nil {
+		t.Errorf("Expected error for nested non-structure")
+	}
+}
+
+func TestCheckGeneratedFill(t *testing.T) {
+	f := filler{rnd: rand.New(rand.NewSource(1))}
+
+	for i := 0; i < 8; i++ {
+		var s unionVPD
+		if err := f.fillStruct(reflect.ValueOf(&s).Elem()); err != nil {
+			t.Fatal(err)
+		}
+
+		switch s.Page.(type) {
+		case unionSerial, *unionDevice:
+		default:
+			t.Errorf("Invalid union case filled: %#v", s.Page)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type greedyEntry struct {
+	ID    uint8
+	Value uint16
+}
+
+type greedyLog struct {
+	Version uint8
+	Entries []greedyEntry `greedy:""`
+}
+
+type greedyPayload struct {
+	Type uint8
+	Data []uint16 `structex:"little,rest"`
+}
+
+func TestGreedySlice(t *testing.T) {
+	tests := []struct {
+		s interface{}
+		b []byte
+	}{
+		{
+			&greedyLog{Version: 1, Entries: []greedyEntry{{1, 0x0201}, {2, 0x0403}}},
+			[]byte{0x01, 0x01, 0x01, 0x02, 0x02, 0x03, 0x04},
+		},
+		{
+			&greedyLog{Version: 1, Entries: []greedyEntry{}},
+			[]byte{0x01},
+		},
+		{
+			&greedyPayload{Type: 7, Data: []uint16{1, 2, 3}},
+			[]byte{0x07, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00},
+		},
+	}
+
+	for _, test := range tests {
+		b := new(bytes.Buffer)
+		if err := Encode(b, test.s); err != nil {
+			t.Fatalf("Encode %T failed: %v", test.s, err)
+		}
+		if !bytes.Equal(b.Bytes(), test.b) {
+			t.Errorf("Invalid %T encoding:\nExpected: %#02x\nActual:   %#02x", test.s, test.b, b.Bytes())
+		}
+
+		if sz, err := Size(test.s); err != nil || sz != uint64(len(test.b)) {
+			t.Errorf("Invalid %T size: Expected: %d Actual: %d %v", test.s, len(test.b), sz, err)
+		}
+
+		s := reflect.New(reflect.TypeOf(test.s).Elem())
+		if err := Decode(bytes.NewReader(test.b), s.Interface()); err != nil {
+			t.Fatalf("Decode %T failed: %v", test.s, err)
+		}
+		if !reflect.DeepEqual(s.Interface(), test.s) {
+			t.Errorf("Invalid %T decode:\nExpected: %+v\nActual:   %+v", test.s, test.s, s.Interface())
+		}
+	}
+}
+
+func TestGreedySliceError(t *testing.T) {
+	// Input ending part way through an element
+	b := []byte{0x01, 0x01, 0x01, 0x02, 0x02, 0x03}
+	err := Decode(bytes.NewReader(b), new(greedyLog))
+	var fe *FieldError
+	if !errors.Is(err, ErrShortBuffer) || !errors.As(err, &fe) || fe.Path != "Entries[1].Value" {
+		t.Errorf("Expected short buffer decoding partial element: Actual: %v", err)
+	}
+
+	b = []byte{0x01, 0x01, 0x01, 0x02, 0x02, 0x03, 0x04}
+	err = DecodeWithOptions(bytes.NewReader(b), new(greedyLog), Options{MaxSliceLen: 1})
+	if !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow decoding beyond slice limit: Actual: %v", err)
+	}
+
+	tests := []interface{}{
+		struct {
+			A uint8 `greedy:""`
+		}{},
+		struct {
+			A []uint8 `greedy:""`
+			B uint8
+		}{},
+		struct {
+			N uint8   `countOf:"A"`
+			A []uint8 `greedy:""`
+		}{},
+	}
+
+	for _, test := range tests {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package tag implements the grammar of structex structure annotations. It
+// is shared by the structex runtime and the tools that inspect annotated
+// structures ahead of time so that all agree on how a tag is read.
+package tag
+
+import (
+	"reflect"
+	"strings"
+)
+
+// An Attr is a single structex annotation, i.e. the key `bitfield` with the
+// value `3,reserved`.
+type Attr struct {
+	Key   string
+	Value string
+}
+
+type options struct {
+	sep    rune
+	quote  rune
+	assign rune
+}
+
+// Parse returns the structex annotations of a structure field tag in the
+// order they appear.
+//
+// Full tag format i.e. `structex:"bitfield='4,reserved',sizeof='Array'"`
+// Bare tag format i.e. `bitfield:"3,reserved" sizeOf:"Array"`
+//
+// When the full format is present the remainder of the tag is ignored.
+func Parse(tag reflect.StructTag) []Attr {
+	if s, ok := tag.Lookup("structex"); ok {
+		return parse(s, options{sep: ',', quote: '\'', assign: '='})
+	}
+	return parse(string(tag), options{sep: ' ', quote: '"', assign: ':'})
+}
+
+// keys are the annotations understood by structex, in lower case.
+var keys = map[string]bool{
+	"little":     true,
+	"big":        true,
+	"bitorder":   true,
+	"bitfield":   true,
+	"sizeof":     true,
+	"countof":    true,
+	"truncate":   true,
+	"greedy":     true,
+	"rest":       true,
+	"until":      true,
+	"terminator": true,
+	"float":      true,
+	"string":     true,
+	"align":      true,
+	"const":      true,
+	"checksum":   true,
+	"if":         true,
+	"switch":     true,
+	"offset":     true,
+}
+
+// IsKey reports whether key is a structex annotation. Keys are compared
+// without regard to case.
+func IsKey(key string) bool {
+	return keys[strings.ToLower(key)]
+}
+
+// IsFull reports whether the tag uses the full `structex:"..."` format.
+func IsFull(tag reflect.StructTag) bool {
+	_, ok := tag.Lookup("structex")
+	return ok
+}
+
+func parse(s string, opts options) []Attr {
+	attrs := []Attr{}
+
+	key := []rune{}
+	val := []rune{}
+
+	assigned := false
+	quoted := false
+
+	flush := func() {
+		if k := strings.TrimSpace(string(key)); len(k) != 0 {
+			attrs = append(attrs, Attr{Key: k, Value: string(val)})
+		}
+		key = key[:0]
+		val = val[:0]
+		assigned = false
+	}
+
+	for _, r := range s {
+		switch {
+		case quoted:
+			if r == opts.quote {
+				quoted = false
+				flush()
+			} else {
+				val = append(val, r)
+			}
+		case r == opts.quote && assigned:
+			quoted = true
+		case r == opts.assign && !assigned:
+			assigned = true
+		case r == opts.sep || r == ',':
+			flush()
+		case assigned:
+			val = append(val, r)
+		default:
+			key = append(key, r)
+		}
+	}
+
+	flush()
+
+	return attrs
+}
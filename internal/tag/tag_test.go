@@ -0,0 +1,65 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package tag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		tag   reflect.StructTag
+		attrs []Attr
+	}{
+		{``, []Attr{}},
+		{`bitfield:"3"`, []Attr{{"bitfield", "3"}}},
+		{`bitfield:"3,reserved" sizeOf:"Array"`, []Attr{{"bitfield", "3,reserved"}, {"sizeOf", "Array"}}},
+		{`truncate:"" big:""`, []Attr{{"truncate", ""}, {"big", ""}}},
+		{`structex:"bitfield='4,reserved',sizeOf='Array'"`, []Attr{{"bitfield", "4,reserved"}, {"sizeOf", "Array"}}},
+		{`structex:"truncate,big"`, []Attr{{"truncate", ""}, {"big", ""}}},
+		{`structex:"align=8" json:"ignored"`, []Attr{{"align", "8"}}},
+	}
+
+	for _, test := range tests {
+		attrs := Parse(test.tag)
+		if !reflect.DeepEqual(attrs, test.attrs) {
+			t.Errorf("Invalid attributes for tag %s: Expected: %v Actual: %v", test.tag, test.attrs, attrs)
+		}
+	}
+}
+
+func TestIsKey(t *testing.T) {
+	for _, key := range []string{"bitfield", "sizeOf", "countOf", "ALIGN", "bitorder"} {
+		if !IsKey(key) {
+			t.Errorf("Expected %s to be a key", key)
+		}
+	}
+
+	for _, key := range []string{"", "json", "bitfeld"} {
+		if IsKey(key) {
+			t.Errorf("Unexpected key %s", key)
+		}
+	}
+}
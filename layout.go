@@ -0,0 +1,411 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// restOf is the name referenced by a sizeOf of the remainder of the
+// enclosing structure, i.e. `sizeOf:"$rest"`
+const restOf = "$rest"
+
+// An origin is the start of the bytes counted by a sizeOf, which otherwise
+// counts the bytes of the referenced field alone.
+type origin int
+
+const (
+	originNone   origin = iota
+	originField         // End of the sizeOf field
+	originStruct        // Start of the structure holding the sizeOf field
+)
+
+// isLayoutTarget reports if a field of kind can be referenced by a layout
+// of format. Only the size of structures, including those held by pointers
+// and unions, can be given.
+func isLayoutTarget(kind reflect.Kind, format int) bool {
+	switch kind {
+	case reflect.Slice, reflect.Array:
+		return true
+	case reflect.Struct, reflect.Ptr, reflect.Interface:
+		return format == sizeOf
+	}
+	return false
+}
+
+// parse parses the name and options of a sizeOf or countOf annotation, i.e.
+// "Descriptors,scale=4,bias=1,from=field"
+func (l *layout) parse(val string) bool {
+	opts := strings.Split(val, ",")
+
+	l.name = opts[0]
+	if len(l.name) == 0 {
+		return false
+	}
+
+	for _, opt := range opts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		key := strings.ToLower(kv[0])
+
+		if len(kv) == 1 {
+			if key != "relative" {
+				return false
+			}
+			l.relative = true
+			continue
+		}
+
+		var err error
+		switch key {
+		case "scale":
+			l.scale, err = strconv.ParseUint(kv[1], 0, 32)
+			if l.scale == 0 {
+				return false
+			}
+		case "bias":
+			l.bias, err = strconv.ParseInt(kv[1], 0, 32)
+		case "from":
+			switch strings.ToLower(kv[1]) {
+			case "field":
+				l.origin = originField
+			case "struct":
+				l.origin = originStruct
+			default:
+				return false
+			}
+		default:
+			return false
+		}
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// quantity returns the size in bytes or count of elements described by the
+// value of the layout field.
+func (l *layout) quantity(value uint64) (uint64, error) {
+	q := int64(value*l.scale) + l.bias
+	if q < 0 {
+		return 0, fmt.Errorf("Layout value %d with bias %d is negative: %w", value, l.bias, ErrOverflow)
+	}
+	return uint64(q), nil
+}
+
+// value returns the value of the layout field describing quantity q.
+func (l *layout) value(q uint64) (uint64, error) {
+	v := int64(q) - l.bias
+	if v < 0 {
+		return 0, fmt.Errorf("Layout of %d is less than the bias of %d: %w", q, l.bias, ErrOverflow)
+	}
+	if uint64(v)%l.scale != 0 {
+		return 0, fmt.Errorf("Layout of %d less bias %d is not a multiple of scale %d: %w", q, l.bias, l.scale, ErrOverflow)
+	}
+	return uint64(v) / l.scale, nil
+}
+
+// elements returns the number of elements of the array or slice arr
+// starting at byte offset start to encode, limited by the value of a layout
+// field already set.
+func (t *transcoder) elements(arr reflect.Value, tags *tags, ref *tagReference, start uint64) uint64 {
+	n := uint64(arr.Len())
+	if ref == nil || ref.value.IsZero() {
+		return n
+	}
+
+	l := &ref.tags.layout
+
+	v, err := getValue(ref.value)
+	if err != nil {
+		return n
+	}
+	q, err := l.quantity(v)
+	if err != nil {
+		return n
+	}
+
+	if l.format == sizeOf {
+		if l.origin != originNone {
+			q -= start - ref.origin
+		}
+
+		sz, err := t.elemSize(arr, tags)
+		if err != nil || sz == 0 {
+			return n
+		}
+		q /= sz
+	}
+
+	if q > n {
+		return n
+	}
+	return q
+}
+
+// layoutValue returns the value encoded by a layout field starting at
+// offset and bit, referencing the field val. A value already set is encoded
+// as is.
+func (t *transcoder) layoutValue(val reflect.Value, ref *tagReference, offset uint64, bit uint64) (uint64, error) {
+	if !ref.value.IsZero() {
+		return getValue(ref.value)
+	}
+
+	l := &ref.tags.layout
+
+	var q uint64
+	switch l.format {
+	case sizeOf:
+		end := offset*8 + bit + ref.tags.bitfield.nbits
+		start := end / 8 // Start of the referenced field
+
+		if l.name == restOf {
+			last, _, err := t.sizeFields(ref.index+1, t.numFields(), end/8, end%8)
+			if err != nil {
+				return 0, err
+			}
+			q = last - start
+			break
+		}
+
+		var target int
+		if l.origin != originNone || !isArray(val.Kind()) {
+			var err error
+			if target, err = t.fieldIndex(l.name); err != nil {
+				return 0, err
+			}
+
+			var sbit uint64
+			if start, sbit, err = t.sizeFields(ref.index+1, target, end/8, end%8); err != nil {
+				return 0, err
+			}
+
+			if !isArray(val.Kind()) {
+				last, _, err := t.sizeFields(target, target+1, start, sbit)
+				if err != nil {
+					return 0, err
+				}
+				q = last - start
+			}
+		}
+
+		if isArray(val.Kind()) && val.Len() != 0 {
+			sz, err := t.elemSize(val, ref.target)
+			if err != nil {
+				return 0, err
+			}
+
+			q = uint64(val.Len()) * sz
+			if l.relative {
+				q -= offset
+			}
+		}
+
+		if l.origin != originNone {
+			q += start - t.origin(l, end/8)
+		}
+
+	case countOf:
+		q = uint64(val.Len())
+	}
+
+	return l.value(q)
+}
+
+// origin returns the byte offset the bytes counted by the layout l start
+// from, given the end of the layout field.
+func (t *transcoder) origin(l *layout, end uint64) uint64 {
+	if l.origin == originStruct {
+		return t.backtrace.vals[t.backtrace.len-1].offset
+	}
+	return end
+}
+
+// bound returns the size in bytes of a field sized by ref and starting at
+// byte offset start, excluding any bytes between its origin and start.
+func (t *transcoder) bound(ref *tagReference, start uint64) (uint64, error) {
+	l := &ref.tags.layout
+
+	q, err := l.quantity(ref.layoutValue)
+	if err != nil {
+		return 0, err
+	}
+
+	if l.origin != originNone {
+		if start-ref.origin > q {
+			return 0, fmt.Errorf("Field starting %d bytes from its origin exceeds size %d: %w", start-ref.origin, q, ErrOverflow)
+		}
+		q -= start - ref.origin
+	}
+
+	return q, nil
+}
+
+// sizeFields returns the byte and bit offset at the end of the fields lo
+// up to hi of the structure currently being transcoded, by sizing them
+// starting at offset and bit.
+func (t *transcoder) sizeFields(lo int, hi int, offset uint64, bit uint64) (uint64, uint64, error) {
+	f := &t.backtrace.vals[t.backtrace.len-1]
+
+	s := &sizer{nbytes: offset, nbits: bit}
+	s.transcoder = t.fork(s)
+
+	for i := lo; i < hi; i++ {
+		if err := s.transcoder.transcodeIndex(f.val, f.plan, i, nil); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return s.nbytes, s.nbits, nil
+}
+
+// fieldIndex returns the index of the named field of the structure
+// currently being transcoded.
+func (t *transcoder) fieldIndex(name string) (int, error) {
+	f := &t.backtrace.vals[t.backtrace.len-1]
+
+	i, ok := f.plan.fieldByName(name)
+	if !ok {
+		return 0, fmt.Errorf("Field '%s' must be in the same structure as its layout: %w", name, ErrTag)
+	}
+
+	return i, nil
+}
+
+// numFields returns the number of fields of the structure currently being
+// transcoded.
+func (t *transcoder) numFields() int {
+	return len(t.backtrace.vals[t.backtrace.len-1].plan.fields)
+}
+
+func isArray(kind reflect.Kind) bool {
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+// errBound is returned reading beyond the size of a field sized by a
+// sizeOf. It matches io.EOF, so greedy and terminated lists and optional
+// trailing fields end at the bound, and ErrOverflow otherwise.
+var errBound error = boundError{}
+
+type boundError struct{}
+
+func (boundError) Error() string {
+	return "structex: contents exceed the size of the field"
+}
+
+func (boundError) Is(target error) bool {
+	return target == io.EOF || target == ErrOverflow
+}
+
+// bounded decodes a field sized by ref and starting at byte offset start
+// with fn, reading no further than its size, then skips any bytes not
+// decoded so records may be extended by later revisions of a format.
+func (d *decoder) bounded(ref *tagReference, start uint64, fn func() error) error {
+	q, err := d.transcoder.bound(ref, start)
+	if err != nil {
+		return err
+	}
+
+	limit := d.limit
+	if d.limit == 0 || start+q < d.limit {
+		d.limit = start + q
+	}
+	err = fn()
+	d.limit = limit
+
+	if err != nil {
+		return err
+	}
+
+	if n := d.byteOffset - start; n > q {
+		return fmt.Errorf("Decoded %d bytes exceeding size of %d: %w", n, q, ErrOverflow)
+	}
+
+	d.bitOffset = 0
+	for d.byteOffset-start < q {
+		if _, err := d.read(8); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bounded encodes a field sized by ref with fn and pads it with zeros up
+// to its size.
+func (e *encoder) bounded(ref *tagReference, start uint64, fn func() error) error {
+	if err := fn(); err != nil {
+		return err
+	}
+
+	q, err := e.transcoder.bound(ref, start)
+	if err != nil {
+		return err
+	}
+
+	if e.bitOffset != 0 {
+		if err := e.write(0, 8-e.bitOffset); err != nil {
+			return err
+		}
+	}
+
+	if n := e.byteOffset - start; n > q {
+		return fmt.Errorf("Encoded %d bytes exceeding size of %d: %w", n, q, ErrOverflow)
+	}
+
+	for e.byteOffset-start < q {
+		if err := e.write(0, 8); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sizer) bounded(ref *tagReference, start uint64, fn func() error) error {
+	if err := fn(); err != nil {
+		return err
+	}
+
+	q, err := s.transcoder.bound(ref, start)
+	if err != nil {
+		return err
+	}
+
+	if s.nbits != 0 {
+		if err := s.addBits(8 - s.nbits); err != nil {
+			return err
+		}
+	}
+
+	if s.nbytes-start < q {
+		return s.addBits((q - (s.nbytes - start)) * 8)
+	}
+
+	return nil
+}
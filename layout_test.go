@@ -0,0 +1,341 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type layoutZeroBased struct {
+	NumEntries uint8 `countOf:"Entries,bias=1"`
+	Entries    []uint16
+}
+
+type layoutDwords struct {
+	Length uint8 `sizeOf:"Data,scale=4"`
+	Data   []uint16
+}
+
+type layoutPage struct {
+	PageCode    uint8
+	Rsvd        uint8
+	PageLength  uint16 `big:"" sizeOf:"Descriptors,from=field"`
+	Generation  uint8
+	Pad         uint8
+	Descriptors []uint32 `big:""`
+}
+
+type layoutInquiry struct {
+	Type    uint8
+	AddLen  uint8 `sizeOf:"Vendor,from=struct,bias=4"`
+	Version uint8
+	Flags   uint8
+	Vendor  []uint8
+}
+
+type layoutNested struct {
+	Prefix  uint16
+	Inquiry layoutInquiry
+}
+
+type layoutBody struct {
+	Value uint16
+	Flags uint8
+}
+
+type layoutVersioned struct {
+	Length  uint8 `sizeOf:"Body"`
+	Body    layoutBody
+	Trailer uint8
+}
+
+type layoutRecord struct {
+	Type   uint8
+	Length uint8 `sizeOf:"$rest"`
+	Value  uint16
+	Flags  uint8
+}
+
+type layoutRecords struct {
+	Count   uint8 `countOf:"Records"`
+	Records []layoutRecord
+}
+
+type layoutTLV struct {
+	Type   uint8
+	Length uint8    `sizeOf:"$rest"`
+	Values []uint16 `greedy:""`
+}
+
+type layoutTLVs struct {
+	Count   uint8 `countOf:"Records"`
+	Records []layoutTLV
+}
+
+type layoutCodes struct {
+	Codes []uint8 `terminator:"0"`
+}
+
+type layoutCoded struct {
+	Length  uint8 `sizeOf:"Body"`
+	Body    layoutCodes
+	Trailer uint8
+}
+
+func TestLayoutExpressions(t *testing.T) {
+	tests := []struct {
+		s   interface{}
+		b   []byte
+		dec interface{}
+	}{
+		{
+			&layoutZeroBased{Entries: []uint16{1, 2, 3}},
+			[]byte{0x02, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00},
+			&layoutZeroBased{NumEntries: 2, Entries: []uint16{1, 2, 3}},
+		},
+		{
+			&layoutDwords{Data: []uint16{1, 2, 3, 4}},
+			[]byte{0x02, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00},
+			&layoutDwords{Length: 2, Data: []uint16{1, 2, 3, 4}},
+		},
+		{
+			&layoutPage{PageCode: 0x83, Generation: 7, Descriptors: []uint32{0x01020304, 0x05060708}},
+			[]byte{0x83, 0x00, 0x00, 0x0A, 0x07, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			&layoutPage{PageCode: 0x83, PageLength: 10, Generation: 7, Descriptors: []uint32{0x01020304, 0x05060708}},
+		},
+		{
+			&layoutNested{Prefix: 0xFFFF, Inquiry: layoutInquiry{Type: 1, Version: 6, Vendor: []uint8{'H', 'P', 'E'}}},
+			[]byte{0xFF, 0xFF, 0x01, 0x03, 0x06, 0x00, 'H', 'P', 'E'},
+			&layoutNested{Prefix: 0xFFFF, Inquiry: layoutInquiry{Type: 1, AddLen: 3, Version: 6, Vendor: []uint8{'H', 'P', 'E'}}},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := EncodeByteBuffer(test.s)
+		if err != nil {
+			t.Fatalf("Encode %T failed: %v", test.s, err)
+		}
+		if !bytes.Equal(b, test.b) {
+			t.Errorf("Invalid %T encoding:\nExpected: %#02x\nActual:   %#02x", test.s, test.b, b)
+		}
+
+		if sz, err := Size(test.s); err != nil || sz != uint64(len(test.b)) {
+			t.Errorf("Invalid %T size: Expected: %d Actual: %d %v", test.s, len(test.b), sz, err)
+		}
+
+		s := reflect.New(reflect.TypeOf(test.s).Elem())
+		if err := Decode(bytes.NewReader(test.b), s.Interface()); err != nil {
+			t.Fatalf("Decode %T failed: %v", test.s, err)
+		}
+		if !reflect.DeepEqual(s.Interface(), test.dec) {
+			t.Errorf("Invalid %T decode:\nExpected: %+v\nActual:   %+v", test.s, test.dec, s.Interface())
+		}
+
+		// Values already set encode the same layout
+		if b, err := EncodeByteBuffer(test.dec); err != nil || !bytes.Equal(b, test.b) {
+			t.Errorf("Invalid %T encoding of decoded value: %#02x %v", test.s, b, err)
+		}
+	}
+}
+
+func TestLayoutExpressionError(t *testing.T) {
+	// A size of 6 bytes is not a whole number of dwords
+	err := Encode(new(bytes.Buffer), layoutDwords{Data: []uint16{1, 2, 3}})
+	if !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow encoding partial dword: Actual: %v", err)
+	}
+
+	// Slice cannot start beyond the bytes counted
+	b := []byte{0x83, 0x00, 0x00, 0x01, 0x07, 0x00}
+	if err := Decode(bytes.NewReader(b), new(layoutPage)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow decoding short page length: Actual: %v", err)
+	}
+
+	tests := []interface{}{
+		struct {
+			N uint8 `countOf:"A,from=field"`
+			A []uint8
+		}{},
+		struct {
+			N uint8 `sizeOf:"A,scale=0"`
+			A []uint8
+		}{},
+		struct {
+			N uint8 `sizeOf:"A,unknown"`
+			A []uint8
+		}{},
+		struct {
+			A []uint8
+			N uint8 `sizeOf:"A,from=struct"`
+		}{},
+	}
+
+	for _, test := range tests {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+	}
+}
+
+func TestLayoutBounds(t *testing.T) {
+	tests := []struct {
+		s interface{}
+		b []byte
+	}{
+		{
+			&layoutVersioned{Body: layoutBody{Value: 0x0201, Flags: 3}, Trailer: 0xFF},
+			[]byte{0x03, 0x01, 0x02, 0x03, 0xFF},
+		},
+		{
+			&layoutVersioned{Length: 5, Body: layoutBody{Value: 0x0201, Flags: 3}, Trailer: 0xFF},
+			[]byte{0x05, 0x01, 0x02, 0x03, 0x00, 0x00, 0xFF},
+		},
+		{
+			&layoutRecord{Type: 1, Value: 0x0201, Flags: 3},
+			[]byte{0x01, 0x03, 0x01, 0x02, 0x03},
+		},
+		{
+			&layoutRecord{Type: 1, Length: 4, Value: 0x0201, Flags: 3},
+			[]byte{0x01, 0x04, 0x01, 0x02, 0x03, 0x00},
+		},
+	}
+
+	for _, test := range tests {
+		b := new(bytes.Buffer)
+		if err := Encode(b, test.s); err != nil {
+			t.Fatalf("Encode %T failed: %v", test.s, err)
+		}
+		if !bytes.Equal(b.Bytes(), test.b) {
+			t.Errorf("Invalid %T encoding:\nExpected: %#02x\nActual:   %#02x", test.s, test.b, b.Bytes())
+		}
+
+		if sz, err := Size(test.s); err != nil || sz != uint64(len(test.b)) {
+			t.Errorf("Invalid %T size: Expected: %d Actual: %d %v", test.s, len(test.b), sz, err)
+		}
+	}
+
+	// Newer versions of a structure append fields which older readers skip
+	b := []byte{0x05, 0x01, 0x02, 0x03, 0xAA, 0xBB, 0xFF}
+	v := new(layoutVersioned)
+	if err := Decode(bytes.NewReader(b), v); err != nil {
+		t.Fatalf("Decode %T failed: %v", v, err)
+	}
+	if exp := (layoutVersioned{Length: 5, Body: layoutBody{Value: 0x0201, Flags: 3}, Trailer: 0xFF}); *v != exp {
+		t.Errorf("Invalid %T decode:\nExpected: %+v\nActual:   %+v", v, exp, *v)
+	}
+
+	b = []byte{0x02,
+		0x01, 0x05, 0x01, 0x02, 0x03, 0xAA, 0xBB,
+		0x02, 0x03, 0x04, 0x05, 0x06,
+	}
+	r := new(layoutRecords)
+	if err := Decode(bytes.NewReader(b), r); err != nil {
+		t.Fatalf("Decode %T failed: %v", r, err)
+	}
+	exp := layoutRecords{Count: 2, Records: []layoutRecord{
+		{Type: 1, Length: 5, Value: 0x0201, Flags: 3},
+		{Type: 2, Length: 3, Value: 0x0504, Flags: 6},
+	}}
+	if !reflect.DeepEqual(*r, exp) {
+		t.Errorf("Invalid %T decode:\nExpected: %+v\nActual:   %+v", r, exp, *r)
+	}
+}
+
+func TestLayoutBoundsList(t *testing.T) {
+	// Open-ended lists end at the size of the region holding them
+	b := []byte{0x02,
+		0x01, 0x04, 0x01, 0x00, 0x02, 0x00,
+		0x02, 0x02, 0x03, 0x00,
+	}
+	r := new(layoutTLVs)
+	if err := Decode(bytes.NewReader(b), r); err != nil {
+		t.Fatalf("Decode %T failed: %v", r, err)
+	}
+	exp := layoutTLVs{Count: 2, Records: []layoutTLV{
+		{Type: 1, Length: 4, Values: []uint16{1, 2}},
+		{Type: 2, Length: 2, Values: []uint16{3}},
+	}}
+	if !reflect.DeepEqual(*r, exp) {
+		t.Errorf("Invalid %T decode:\nExpected: %+v\nActual:   %+v", r, exp, *r)
+	}
+	if enc, err := EncodeByteBuffer(r); err != nil || !bytes.Equal(enc, b) {
+		t.Errorf("Invalid %T encoding: %#02x %v", r, enc, err)
+	}
+
+	b = []byte{0x04, 0x01, 0x02, 0x00, 0xAA, 0xFF}
+	c := new(layoutCoded)
+	if err := Decode(bytes.NewReader(b), c); err != nil {
+		t.Fatalf("Decode %T failed: %v", c, err)
+	}
+	if exp := (layoutCoded{Length: 4, Body: layoutCodes{Codes: []uint8{1, 2}}, Trailer: 0xFF}); !reflect.DeepEqual(*c, exp) {
+		t.Errorf("Invalid %T decode:\nExpected: %+v\nActual:   %+v", c, exp, *c)
+	}
+
+	// A terminator beyond the size is not read
+	b = []byte{0x02, 0x01, 0x02, 0x00, 0xFF}
+	if err := Decode(bytes.NewReader(b), new(layoutCoded)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow decoding unterminated list: Actual: %v", err)
+	}
+}
+
+func TestLayoutBoundsError(t *testing.T) {
+	// Contents cannot exceed the size given
+	b := []byte{0x02, 0x01, 0x02, 0x03, 0xFF}
+	if err := Decode(bytes.NewReader(b), new(layoutVersioned)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow decoding short structure: Actual: %v", err)
+	}
+
+	b = []byte{0x01, 0x02, 0x01, 0x02, 0x03}
+	if err := Decode(bytes.NewReader(b), new(layoutRecord)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow decoding short remainder: Actual: %v", err)
+	}
+
+	if err := Encode(new(bytes.Buffer), layoutRecord{Length: 2}); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow encoding short remainder: Actual: %v", err)
+	}
+
+	tests := []interface{}{
+		struct {
+			N uint8 `countOf:"$rest"`
+			A uint8
+		}{},
+		struct {
+			A layoutBody
+			N uint8 `sizeOf:"A"`
+		}{},
+		struct {
+			N uint8 `countOf:"A"`
+			A layoutBody
+		}{},
+	}
+
+	for _, test := range tests {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// BitWriter is the bit-level stream written by Encode. Values are packed in
+// the bit order of the field holding the Marshaler, least significant bit
+// first unless annotated or set by Options.BitOrder, continuing from where
+// the previous value finished.
+type BitWriter interface {
+	// WriteBits writes the low nbits of value to the stream.
+	WriteBits(value uint64, nbits uint64) error
+
+	// WriteBigEndian writes the low nbits of value to the stream most
+	// significant bits first, each chunk filling the remainder of the
+	// current byte. Values of 8 bits or fewer are written as by WriteBits.
+	WriteBigEndian(value uint64, nbits uint64) error
+
+	// Fit returns the value to write for a field of nbits holding value,
+	// the bits of a signed integer if signed, as selected by
+	// Options.Overflow. Values that do not fit are an ErrOverflow error
+	// unless truncated or saturated.
+	Fit(value uint64, nbits uint64, signed bool) (uint64, error)
+
+	// Reserved returns the value to write for a reserved field of nbits
+	// holding value, as selected by Options.Reserved.
+	Reserved(value uint64, nbits uint64) (uint64, error)
+
+	// Align pads the stream with zeros to the next multiple of n bytes.
+	Align(n uint64) error
+
+	// Offset returns the number of whole bytes written to the stream.
+	Offset() uint64
+
+	// BigEndian reports if fields without an endian annotation are
+	// encoded in big-endian format.
+	BigEndian() bool
+}
+
+// BitReader is the bit-level stream read by Decode. Values are unpacked in
+// the bit order of the field holding the Unmarshaler, as by BitWriter,
+// continuing from where the previous value finished.
+type BitReader interface {
+	// ReadBits reads nbits from the stream.
+	ReadBits(nbits uint64) (uint64, error)
+
+	// ReadBigEndian reads nbits from the stream written as by
+	// BitWriter.WriteBigEndian.
+	ReadBigEndian(nbits uint64) (uint64, error)
+
+	// Reserved checks the value just read for the reserved field name of
+	// nbits. Non-zero values are an ErrReserved error if Options.Strict is
+	// set, and are otherwise recorded in any Options.Report.
+	Reserved(name string, value uint64, nbits uint64) error
+
+	// SliceLen checks the length n of a slice about to be allocated
+	// against Options.MaxSliceLen, returning an ErrOverflow error if it is
+	// exceeded.
+	SliceLen(n uint64) error
+
+	// Align discards bits up to the next multiple of n bytes.
+	Align(n uint64) error
+
+	// Offset returns the number of whole bytes read from the stream.
+	Offset() uint64
+
+	// BigEndian reports if fields without an endian annotation are
+	// decoded in big-endian format.
+	BigEndian() bool
+}
+
+// Field describes the annotations of the structure field holding a value
+// that implements Marshaler, Unmarshaler or Sizer. Values that are not held
+// by an annotated field, such as the top level structure, receive a Field
+// with only the BigEndian member set.
+type Field struct {
+	// BigEndian reports if the field is to be transcoded in big-endian
+	// format, considering both the field annotation and the default.
+	BigEndian bool
+
+	// Bits is the size of the field in bits as given by a bitfield
+	// annotation or, for integer types, by the type itself. Zero otherwise.
+	Bits uint64
+
+	// Reserved reports if the field is annotated as reserved.
+	Reserved bool
+
+	// Truncate reports if the field is annotated with truncate.
+	Truncate bool
+
+	// Tag is the raw structure field tag, allowing types to define and
+	// parse their own annotations.
+	Tag reflect.StructTag
+}
+
+// Marshaler is implemented by types that encode themselves. Encode calls
+// MarshalStructex in place of reflecting over the value, for the top level
+// structure and for every nested field, array or slice element. Code
+// generated by structexgen implements Marshaler.
+type Marshaler interface {
+	MarshalStructex(w BitWriter, f Field) error
+}
+
+// Unmarshaler is implemented by types that decode themselves. Decode calls
+// UnmarshalStructex in place of reflecting over the value. Code generated by
+// structexgen implements Unmarshaler.
+type Unmarshaler interface {
+	UnmarshalStructex(r BitReader, f Field) error
+}
+
+// Sizer is implemented by types that report their own encoded size, in bits.
+// Size uses SizeStructex if available, otherwise the size of a Marshaler is
+// measured by running MarshalStructex against a counting BitWriter.
+type Sizer interface {
+	SizeStructex(f Field) (uint64, error)
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	sizerType       = reflect.TypeOf((*Sizer)(nil)).Elem()
+)
+
+type customKey struct {
+	typ   reflect.Type
+	iface reflect.Type
+}
+
+var customTypes sync.Map // map[customKey]bool
+
+// implements reports if values of typ, or their address, implement iface.
+func implements(typ reflect.Type, iface reflect.Type) bool {
+	key := customKey{typ, iface}
+	if c, ok := customTypes.Load(key); ok {
+		return c.(bool)
+	}
+
+	c := reflect.PtrTo(typ).Implements(iface)
+
+	customTypes.Store(key, c)
+	return c
+}
+
+// isCustom reports if values of typ transcode themselves for any of
+// Encode, Decode or Size. Each handler decides for itself through handles.
+func isCustom(typ reflect.Type) bool {
+	return implements(typ, marshalerType) || implements(typ, unmarshalerType) || implements(typ, sizerType)
+}
+
+// asInterface returns the value, or its address if addressable, for
+// asserting against the custom interfaces. Pointer methods of values that
+// are not addressable are reached through a copy.
+func asInterface(val reflect.Value) (interface{}, error) {
+	if !val.CanInterface() {
+		return nil, fmt.Errorf("Field of type %s cannot be accessed. Make sure it is exported.", val.Type().String())
+	}
+
+	if val.CanAddr() {
+		return val.Addr().Interface(), nil
+	}
+
+	p := reflect.New(val.Type())
+	p.Elem().Set(val)
+	return p.Interface(), nil
+}
+
+// fieldOf returns the public description of the field annotations.
+func (t *transcoder) fieldOf(tags *tags) Field {
+	f := Field{
+		BigEndian: t.bigEndian(tags),
+	}
+
+	if tags != nil {
+		f.Bits = tags.bitfield.nbits
+		f.Reserved = tags.bitfield.reserved
+		f.Truncate = tags.truncate
+		f.Tag = tags.tag
+	}
+
+	return f
+}
+
+// Values wider than a byte are written in the byte order natural for the
+// bit order, or reversed as for any other field. See encoder.writeValue.
+func (e *encoder) WriteBits(value uint64, nbits uint64) error {
+	if nbits > 8 && e.customOrder == msbFirst {
+		return e.writeReversed(value, nbits, e.customOrder)
+	}
+	return e.writeOrdered(value, nbits, e.customOrder)
+}
+
+func (e *encoder) WriteBigEndian(value uint64, nbits uint64) error {
+	if nbits > 8 && e.customOrder == lsbFirst {
+		return e.writeReversed(value, nbits, e.customOrder)
+	}
+	return e.writeOrdered(value, nbits, e.customOrder)
+}
+
+func (e *encoder) Fit(value uint64, nbits uint64, signed bool) (uint64, error) {
+	return e.transcoder.options.fit(value, nbits, signed)
+}
+
+func (e *encoder) Reserved(value uint64, nbits uint64) (uint64, error) {
+	return e.transcoder.options.reservedValue(value, nbits)
+}
+
+func (e *encoder) Align(n uint64) error {
+	return e.align(alignment(n))
+}
+
+func (e *encoder) Offset() uint64 {
+	return e.byteOffset
+}
+
+func (e *encoder) BigEndian() bool {
+	return e.transcoder.defaultEndianness == big
+}
+
+// handles reports if values of typ encode themselves.
+func (e *encoder) handles(typ reflect.Type) bool {
+	return implements(typ, marshalerType)
+}
+
+func (e *encoder) custom(val reflect.Value, tags *tags) error {
+	i, err := asInterface(val)
+	if err != nil {
+		return err
+	}
+
+	m, ok := i.(Marshaler)
+	if !ok {
+		return fmt.Errorf("Field type %s does not implement Marshaler", val.Type().String())
+	}
+
+	order := e.customOrder
+	e.customOrder = e.transcoder.bitOrder(tags)
+	defer func() { e.customOrder = order }()
+
+	return m.MarshalStructex(e, e.transcoder.fieldOf(tags))
+}
+
+func (d *decoder) ReadBits(nbits uint64) (uint64, error) {
+	if nbits > 8 && d.customOrder == msbFirst {
+		return d.readReversed(nbits, d.customOrder)
+	}
+	return d.readOrdered(nbits, d.customOrder)
+}
+
+func (d *decoder) ReadBigEndian(nbits uint64) (uint64, error) {
+	if nbits > 8 && d.customOrder == lsbFirst {
+		return d.readReversed(nbits, d.customOrder)
+	}
+	return d.readOrdered(nbits, d.customOrder)
+}
+
+func (d *decoder) Reserved(name string, value uint64, nbits uint64) error {
+	if value == 0 {
+		return nil
+	}
+
+	// The field started nbits before the current position
+	offset, bit := d.position()
+	start := offset*8 + bit - nbits
+
+	d.transcoder.enter(name, 0)
+	defer d.transcoder.leave()
+
+	if err := d.reserved(value, start/8, start%8); err != nil {
+		return fieldError(err, name, start/8, start%8)
+	}
+	return nil
+}
+
+func (d *decoder) SliceLen(n uint64) error {
+	if max := d.transcoder.options.MaxSliceLen; max != 0 && n > max {
+		return fmt.Errorf("Slice length %d exceeds limit of %d elements: %w", n, max, ErrOverflow)
+	}
+	return nil
+}
+
+func (d *decoder) Align(n uint64) error {
+	return d.align(alignment(n))
+}
+
+func (d *decoder) Offset() uint64 {
+	return d.byteOffset
+}
+
+func (d *decoder) BigEndian() bool {
+	return d.transcoder.defaultEndianness == big
+}
+
+// handles reports if values of typ decode themselves.
+func (d *decoder) handles(typ reflect.Type) bool {
+	return implements(typ, unmarshalerType)
+}
+
+func (d *decoder) custom(val reflect.Value, tags *tags) error {
+	if !val.CanAddr() || !val.CanSet() {
+		return fmt.Errorf("Field of type %s cannot be set. Make sure it is exported.", val.Type().String())
+	}
+
+	u, ok := val.Addr().Interface().(Unmarshaler)
+	if !ok {
+		return fmt.Errorf("Field type %s does not implement Unmarshaler", val.Type().String())
+	}
+
+	order := d.customOrder
+	d.customOrder = d.transcoder.bitOrder(tags)
+	defer func() { d.customOrder = order }()
+
+	return u.UnmarshalStructex(d, d.transcoder.fieldOf(tags))
+}
+
+func (s *sizer) WriteBits(value uint64, nbits uint64) error {
+	return s.addBits(nbits)
+}
+
+func (s *sizer) WriteBigEndian(value uint64, nbits uint64) error {
+	return s.addBits(nbits)
+}
+
+func (s *sizer) Fit(value uint64, nbits uint64, signed bool) (uint64, error) {
+	return value, nil
+}
+
+func (s *sizer) Reserved(value uint64, nbits uint64) (uint64, error) {
+	return value, nil
+}
+
+func (s *sizer) Align(n uint64) error {
+	return s.align(alignment(n))
+}
+
+func (s *sizer) Offset() uint64 {
+	return s.nbytes
+}
+
+func (s *sizer) BigEndian() bool {
+	return s.transcoder.defaultEndianness == big
+}
+
+// handles reports if values of typ report their size, or can be measured
+// by encoding them.
+func (s *sizer) handles(typ reflect.Type) bool {
+	return implements(typ, sizerType) || implements(typ, marshalerType)
+}
+
+func (s *sizer) custom(val reflect.Value, tags *tags) error {
+	f := s.transcoder.fieldOf(tags)
+
+	i, err := asInterface(val)
+	if err != nil {
+		return err
+	}
+
+	switch c := i.(type) {
+	case Sizer:
+		nbits, err := c.SizeStructex(f)
+		if err != nil {
+			return err
+		}
+		return s.addBits(nbits)
+	case Marshaler:
+		// The sizer counts the bits written in place of writing them.
+		return c.MarshalStructex(s, f)
+	}
+
+	return fmt.Errorf("Cannot determine size of field type %s", val.Type().String())
+}
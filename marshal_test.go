@@ -0,0 +1,328 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// timestamp is a 48-bit count of milliseconds
+type timestamp uint64
+
+func (ts timestamp) MarshalStructex(w BitWriter, f Field) error {
+	v := uint64(ts)
+	if f.Tag.Get("unit") == "s" {
+		v /= 1000
+	}
+
+	for i := 0; i < 6; i++ {
+		shift := uint64(8 * i)
+		if f.BigEndian {
+			shift = uint64(8 * (5 - i))
+		}
+		if err := w.WriteBits((v>>shift)&0xFF, 8); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ts *timestamp) UnmarshalStructex(r BitReader, f Field) error {
+	v := uint64(0)
+	for i := 0; i < 6; i++ {
+		b, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		if f.BigEndian {
+			v = v<<8 | b
+		} else {
+			v |= b << uint64(8*i)
+		}
+	}
+
+	if f.Tag.Get("unit") == "s" {
+		v *= 1000
+	}
+
+	*ts = timestamp(v)
+	return nil
+}
+
+func (ts timestamp) SizeStructex(f Field) (uint64, error) {
+	return 48, nil
+}
+
+// blob is a length prefixed opaque payload with no Sizer.
+type blob struct {
+	data []byte
+}
+
+func (b blob) MarshalStructex(w BitWriter, f Field) error {
+	if err := w.WriteBits(uint64(len(b.data)), 8); err != nil {
+		return err
+	}
+	for _, v := range b.data {
+		if err := w.WriteBits(uint64(v), 8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *blob) UnmarshalStructex(r BitReader, f Field) error {
+	n, err := r.ReadBits(8)
+	if err != nil {
+		return err
+	}
+
+	b.data = make([]byte, n)
+	for i := range b.data {
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return err
+		}
+		b.data[i] = byte(v)
+	}
+	return nil
+}
+
+type customRecord struct {
+	Flags  uint8
+	When   timestamp `big:"" unit:"s"`
+	Stamps [2]timestamp
+	Blob   blob
+	Tail   uint8
+}
+
+func TestCustomEncoder(t *testing.T) {
+	s := customRecord{
+		Flags:  0xA5,
+		When:   0x010203040506 * 1000,
+		Stamps: [2]timestamp{1, 2},
+		Blob:   blob{data: []byte{0xDE, 0xAD}},
+		Tail:   0x5A,
+	}
+
+	expected := []byte{
+		0xA5,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x02, 0xDE, 0xAD,
+		0x5A,
+	}
+
+	packAndTest(t, s, func(t *testing.T, tw *testWriter) {
+		if !bytes.Equal(tw.getBytes(0, tw.getSize()-1), expected) {
+			t.Errorf("Invalid custom encoding:\nExpected: %x\nActual:   %x", expected, tw.getBytes(0, tw.getSize()-1))
+		}
+	})
+
+	sz, err := Size(s)
+	if err != nil {
+		t.Fatalf("Size failed: %v", err)
+	}
+	if sz != uint64(len(expected)) {
+		t.Errorf("Invalid custom size: Expected: %d Actual: %d", len(expected), sz)
+	}
+}
+
+func TestCustomDecoder(t *testing.T) {
+	tr := newReader([]byte{
+		0xA5,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x02, 0xDE, 0xAD,
+		0x5A,
+	})
+
+	unpackAndTest(t, new(customRecord), tr, func(t *testing.T, i interface{}) {
+		s := i.(*customRecord)
+
+		if s.When != 0x010203040506*1000 {
+			t.Errorf("Invalid big-endian timestamp: Expected: %#x Actual: %#x", 0x010203040506*1000, uint64(s.When))
+		}
+		if s.Stamps[0] != 1 || s.Stamps[1] != 2 {
+			t.Errorf("Invalid timestamp array: %v", s.Stamps)
+		}
+		if !bytes.Equal(s.Blob.data, []byte{0xDE, 0xAD}) {
+			t.Errorf("Invalid blob: %x", s.Blob.data)
+		}
+		if s.Tail != 0x5A {
+			t.Errorf("Invalid tail: Expected: %#02x Actual: %#02x", 0x5A, s.Tail)
+		}
+	})
+}
+
+func TestCustomSliceSize(t *testing.T) {
+	type ts struct {
+		Size   uint8 `sizeOf:"Stamps"`
+		Stamps []timestamp
+	}
+
+	s := new(ts)
+	b := bytes.NewBuffer([]byte{12, 1, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0})
+	if err := DecodeByteBuffer(b, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(s.Stamps) != "[1 2]" {
+		t.Errorf("Invalid timestamps: %v", s.Stamps)
+	}
+}
+
+// swapped decodes its fields in reverse order but is otherwise reflected.
+type swapped struct {
+	A uint8
+	B uint8
+}
+
+func (s *swapped) UnmarshalStructex(r BitReader, f Field) error {
+	b, err := r.ReadBits(8)
+	if err != nil {
+		return err
+	}
+	a, err := r.ReadBits(8)
+	if err != nil {
+		return err
+	}
+
+	s.A, s.B = uint8(a), uint8(b)
+	return nil
+}
+
+// sized reports a size but is otherwise reflected.
+type sized struct {
+	Value uint16
+}
+
+func (s sized) SizeStructex(f Field) (uint64, error) {
+	return 16, nil
+}
+
+// beWord encodes itself big-endian but is otherwise reflected.
+type beWord uint16
+
+func (w beWord) MarshalStructex(bw BitWriter, f Field) error {
+	return bw.WriteBigEndian(uint64(w), 16)
+}
+
+func TestCustomPartial(t *testing.T) {
+	s := struct {
+		Swapped swapped
+		Sized   sized
+		Word    beWord
+	}{swapped{1, 2}, sized{0x0304}, 0x0506}
+
+	b := new(bytes.Buffer)
+	if err := Encode(b, &s); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if expected := []byte{0x01, 0x02, 0x04, 0x03, 0x05, 0x06}; !bytes.Equal(b.Bytes(), expected) {
+		t.Errorf("Invalid partial custom encoding:\nExpected: %x\nActual:   %x", expected, b.Bytes())
+	}
+
+	if sz, err := Size(&s); err != nil || sz != 6 {
+		t.Errorf("Invalid partial custom size: Expected: 6 Actual: %d %v", sz, err)
+	}
+
+	d := s
+	d.Swapped, d.Sized, d.Word = swapped{}, sized{}, 0
+	if err := Decode(bytes.NewReader(b.Bytes()), &d); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if d.Swapped != (swapped{2, 1}) || d.Sized != (sized{0x0304}) || d.Word != 0x0605 {
+		t.Errorf("Invalid partial custom decode: %+v", d)
+	}
+}
+
+// nibbles encodes itself as would be reflected.
+type nibbles struct {
+	A uint8 `bitfield:"4"`
+	B uint8 `bitfield:"4"`
+	C uint16
+}
+
+func (n nibbles) MarshalStructex(w BitWriter, f Field) error {
+	for _, v := range []struct{ value, nbits uint64 }{{uint64(n.A), 4}, {uint64(n.B), 4}, {uint64(n.C), 16}} {
+		if err := w.WriteBits(v.value, v.nbits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *nibbles) UnmarshalStructex(r BitReader, f Field) error {
+	a, err := r.ReadBits(4)
+	if err != nil {
+		return err
+	}
+	b, err := r.ReadBits(4)
+	if err != nil {
+		return err
+	}
+	c, err := r.ReadBits(16)
+	if err != nil {
+		return err
+	}
+
+	n.A, n.B, n.C = uint8(a), uint8(b), uint16(c)
+	return nil
+}
+
+func TestCustomBitOrder(t *testing.T) {
+	type reflected struct {
+		A uint8 `bitfield:"4"`
+		B uint8 `bitfield:"4"`
+		C uint16
+	}
+
+	for _, order := range []BitOrder{LSBFirst, MSBFirst} {
+		opts := Options{BitOrder: order}
+
+		expected, actual := new(bytes.Buffer), new(bytes.Buffer)
+		if err := EncodeWithOptions(expected, &reflected{0xA, 0x5, 0x0102}, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := EncodeWithOptions(actual, &nibbles{0xA, 0x5, 0x0102}, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+			t.Errorf("Invalid custom encoding in bit order %d:\nExpected: %x\nActual:   %x", order, expected.Bytes(), actual.Bytes())
+		}
+
+		var n nibbles
+		if err := DecodeWithOptions(bytes.NewReader(expected.Bytes()), &n, opts); err != nil {
+			t.Fatal(err)
+		}
+		if n != (nibbles{0xA, 0x5, 0x0102}) {
+			t.Errorf("Invalid custom decode in bit order %d: %+v", order, n)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// readAhead is the number of bytes read from an io.ReaderAt at a time.
+const readAhead = 512
+
+// maxJumps bounds the depth of fields at an offset within fields at an
+// offset, so long chains of records in hostile data cannot exhaust the
+// stack.
+const maxJumps = 1024
+
+// jump is a field at an offset being decoded. The same type at the same
+// offset while still decoding it is a loop.
+type jump struct {
+	off uint64
+	typ reflect.Type
+}
+
+// readerAt reads bytes from an io.ReaderAt at a position that may be moved
+// to decode fields at an offset.
+type readerAt struct {
+	r    io.ReaderAt
+	off  int64  // Position of the next byte
+	buf  []byte // Bytes read ahead starting at base
+	base int64
+}
+
+func (r *readerAt) ReadByte() (byte, error) {
+	if i := r.off - r.base; i < 0 || i >= int64(len(r.buf)) {
+		if r.buf == nil {
+			r.buf = make([]byte, readAhead)
+		}
+
+		// ReaderAt returns an error with fewer bytes than requested,
+		// so only fail if there are none at all.
+		n, err := r.r.ReadAt(r.buf[:cap(r.buf)], r.off)
+		r.buf, r.base = r.buf[:n], r.off
+		if n == 0 {
+			if err == nil {
+				err = io.ErrNoProgress
+			}
+			return 0, err
+		}
+	}
+
+	b := r.buf[r.off-r.base]
+	r.off++
+	return b, nil
+}
+
+/*
+DecodeAt deserializes the data read from r into the structure s, as Decode,
+starting at offset 0. Fields annotated with offset are decoded at the offset
+held by another field, which Decode does not support.
+
+	`offset:"[Field][,relative]"`
+
+	Field		The integer field holding the byte offset of the annotated
+				field from the start of the data.
+
+	relative	Optional modifier that specifies the offset is relative to
+				the start of the structure holding the annotated field.
+
+A pointer field at a zero offset is left nil, so chains of records linked by
+offsets, such as PCI capability lists, decode as a linked list. Chains that
+loop back to a record being decoded, or nest deeper than 1024 offsets, fail
+with ErrOverflow.
+*/
+func DecodeAt(r io.ReaderAt, s interface{}) error {
+	return DecodeAtWithOptions(r, s, Options{})
+}
+
+// DecodeAtWithOptions is DecodeAt with the defaults and checks given by opts.
+func DecodeAtWithOptions(r io.ReaderAt, s interface{}, opts Options) error {
+	at := &readerAt{r: r}
+
+	d := decoder{
+		reader: at,
+		at:     at,
+	}
+
+	return d.decode(s, opts)
+}
+
+// offset decodes the field from the offset held by the referenced field
+// and returns to the position the field would otherwise have been read.
+func (d *decoder) offset(t *transcoder, val reflect.Value, field *fieldPlan) error {
+	loc := field.tags.offset
+
+	if d.at == nil {
+		return fmt.Errorf("Field at offset of '%s' can only be decoded by DecodeAt: %w", loc.name, ErrTag)
+	}
+
+	ref, _ := t.fieldByName(loc.name)
+	if !ref.IsValid() {
+		return fmt.Errorf("cannot locate referenced field '%s': %w", loc.name, ErrTag)
+	}
+	if !isInteger(ref.Kind()) {
+		return fmt.Errorf("referenced offset must be of integer type; is of type %s: %w", ref.Kind().String(), ErrTag)
+	}
+
+	off, err := getValue(ref)
+	if err != nil {
+		return err
+	}
+	if off == 0 && field.kind == reflect.Ptr {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+	if loc.relative {
+		off += t.backtrace.vals[t.backtrace.len-1].offset
+	}
+
+	j := jump{off, val.Type()}
+	if d.jumps[j] {
+		return fmt.Errorf("Offset %d of '%s' loops back to a field being decoded: %w", off, loc.name, ErrOverflow)
+	}
+	if len(d.jumps) >= maxJumps {
+		return fmt.Errorf("Offset %d of '%s' exceeds %d nested offsets: %w", off, loc.name, maxJumps, ErrOverflow)
+	}
+	if d.jumps == nil {
+		d.jumps = make(map[jump]bool)
+	}
+	d.jumps[j] = true
+	defer delete(d.jumps, j)
+
+	saved, pos := *d, d.at.off
+	defer func() {
+		*d = saved
+		d.at.off = pos
+	}()
+
+	// Bytes at an offset are not part of any checksummed range, nor
+	// bounded by the size of the field referencing them
+	d.recording = false
+	d.limit = 0
+	d.byteOffset, d.bitOffset = off, 0
+	d.at.off = int64(off)
+
+	elem := *field
+	elem.tags.offset = location{}
+
+	return t.transcodeField(val, &elem)
+}
+
+// offset skips fields at an offset; they are not encoded.
+func (e *encoder) offset(t *transcoder, val reflect.Value, field *fieldPlan) error {
+	return nil
+}
+
+func (s *sizer) offset(t *transcoder, val reflect.Value, field *fieldPlan) error {
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type offsetCap struct {
+	ID      uint8
+	Next    uint8
+	NextCap *offsetCap `offset:"Next"`
+}
+
+type offsetChain struct {
+	ID      uint8
+	Next    uint16
+	NextCap *offsetChain `offset:"Next"`
+}
+
+type offsetPCI struct {
+	Vendor uint16
+	CapPtr uint8
+	Caps   *offsetCap `offset:"CapPtr"`
+	Class  uint8
+}
+
+type offsetEntry struct {
+	Type  uint8
+	Start uint16
+}
+
+type offsetSub struct {
+	Magic uint8
+	Rel   uint8
+	Data  uint16 `offset:"Rel,relative"`
+}
+
+type offsetGPT struct {
+	Signature   uint8
+	TableOffset uint32
+	Table       [2]offsetEntry `offset:"TableOffset"`
+	Sub         offsetSub
+}
+
+func TestDecodeAt(t *testing.T) {
+	b := make([]byte, 32)
+	copy(b, []byte{0x86, 0x80, 0x10, 0x02})
+	copy(b[0x10:], []byte{0x01, 0x18})
+	copy(b[0x18:], []byte{0x05, 0x00})
+
+	var pci offsetPCI
+	if err := DecodeAt(bytes.NewReader(b), &pci); err != nil {
+		t.Fatalf("DecodeAt failed: %v", err)
+	}
+
+	expected := offsetPCI{
+		Vendor: 0x8086,
+		CapPtr: 0x10,
+		Caps:   &offsetCap{ID: 1, Next: 0x18, NextCap: &offsetCap{ID: 5}},
+		Class:  2,
+	}
+	if !reflect.DeepEqual(pci, expected) {
+		t.Errorf("Invalid capability list:\nExpected: %+v\nActual:   %+v", expected, pci)
+	}
+
+	b = make([]byte, 0x16)
+	copy(b, []byte{0xAA, 0x10, 0x00, 0x00, 0x00, 0x55, 0x04, 0x00, 0x00, 0x34, 0x12})
+	copy(b[0x10:], []byte{0x01, 0x00, 0x01, 0x02, 0x00, 0x02})
+
+	var gpt offsetGPT
+	if err := DecodeAt(bytes.NewReader(b), &gpt); err != nil {
+		t.Fatalf("DecodeAt failed: %v", err)
+	}
+
+	expectedGPT := offsetGPT{
+		Signature:   0xAA,
+		TableOffset: 0x10,
+		Table:       [2]offsetEntry{{1, 0x100}, {2, 0x200}},
+		Sub:         offsetSub{Magic: 0x55, Rel: 4, Data: 0x1234},
+	}
+	if !reflect.DeepEqual(gpt, expectedGPT) {
+		t.Errorf("Invalid table decode:\nExpected: %+v\nActual:   %+v", expectedGPT, gpt)
+	}
+
+	// Fields at an offset are not encoded
+	if sz, err := Size(gpt); err != nil || sz != 7 {
+		t.Errorf("Invalid size: Expected: 7 Actual: %d %v", sz, err)
+	}
+
+	for _, v := range []interface{}{offsetPCI{}, offsetGPT{}} {
+		if errs := Validate(v); len(errs) != 0 {
+			t.Errorf("Unexpected validation errors for %T: %v", v, errs)
+		}
+	}
+}
+
+func TestDecodeAtError(t *testing.T) {
+	b := []byte{0xAA, 0x00, 0x01, 0x00, 0x00, 0x55, 0x00}
+
+	var fe *FieldError
+	err := DecodeAt(bytes.NewReader(b), new(offsetGPT))
+	if !errors.Is(err, ErrShortBuffer) || !errors.As(err, &fe) || fe.Path != "Table[0].Type" {
+		t.Errorf("Expected short buffer decoding Table: Actual: %v", err)
+	}
+
+	// Capabilities linked in a loop
+	b = []byte{0x86, 0x80, 0x04, 0x00, 0x01, 0x06, 0x05, 0x04}
+	if err := DecodeAt(bytes.NewReader(b), new(offsetPCI)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow decoding looped capabilities: Actual: %v", err)
+	}
+
+	// Chains longer than any real structure
+	b = make([]byte, 3*maxJumps+8)
+	b[0] = 0x02
+	for i := 2; i+3 < len(b); i += 3 {
+		b[i], b[i+1], b[i+2] = 0x01, uint8(i+3), uint8((i+3)>>8)
+	}
+	chain := struct {
+		Ptr  uint16
+		Caps *offsetChain `offset:"Ptr"`
+	}{}
+	if err := DecodeAt(bytes.NewReader(b), &chain); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected overflow decoding long chain: Actual: %v", err)
+	}
+
+	b = []byte{0xAA, 0x00, 0x01, 0x00, 0x00, 0x55, 0x00}
+	if err := Decode(bytes.NewReader(b), new(offsetGPT)); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected tagging error decoding offset without DecodeAt: Actual: %v", err)
+	}
+
+	tests := []interface{}{
+		struct {
+			A uint8 `offset:"B"`
+			B uint8
+		}{},
+		struct {
+			B [2]uint8
+			A uint8 `offset:"B"`
+		}{},
+	}
+
+	for _, test := range tests {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+		if errs := Validate(test); len(errs) != 1 {
+			t.Errorf("Expected a validation error for %T: Actual: %v", test, errs)
+		}
+	}
+}
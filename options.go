@@ -0,0 +1,225 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ByteOrder selects the byte order of fields without an endian annotation.
+type ByteOrder int
+
+const (
+	// DefaultByteOrder uses the byte order named by the environment
+	// variable EnvVarDefaultEndianness if set, otherwise little-endian.
+	DefaultByteOrder ByteOrder = iota
+	LittleEndian
+	BigEndian
+)
+
+// BitOrder selects the order in which bitfields are allocated within each
+// byte for structures and fields without a bit order annotation.
+type BitOrder int
+
+const (
+	// DefaultBitOrder allocates bitfields least significant bit first.
+	DefaultBitOrder BitOrder = iota
+	LSBFirst
+	MSBFirst
+)
+
+// ReservedMode selects what is encoded in fields annotated as reserved.
+type ReservedMode int
+
+const (
+	// ReservedZero encodes reserved fields as zeros.
+	ReservedZero ReservedMode = iota
+
+	// ReservedFill encodes reserved fields with the ReservedFill byte
+	// pattern, i.e. 0xFF for formats reserving bits as ones.
+	ReservedFill
+
+	// ReservedPreserve encodes the value held by reserved fields, so
+	// reserved contents round-trip unchanged through Decode and Encode.
+	ReservedPreserve
+)
+
+// OverflowMode selects how values that do not fit their field are encoded.
+type OverflowMode int
+
+const (
+	// OverflowError fails the encode with ErrOverflow.
+	OverflowError OverflowMode = iota
+
+	// OverflowTruncate encodes the low bits of the value.
+	OverflowTruncate
+
+	// OverflowSaturate encodes the nearest value the field can hold.
+	OverflowSaturate
+)
+
+/*
+Options control a single call to EncodeWithOptions, DecodeWithOptions or
+SizeWithOptions. The zero value gives the behavior of Encode, Decode and
+Size. Annotations on a structure or field always take precedence over the
+defaults given here.
+*/
+type Options struct {
+	// ByteOrder is the byte order of fields without an endian annotation.
+	ByteOrder ByteOrder
+
+	// BitOrder is the bit order of structures without a bit order
+	// annotation, including values implementing Marshaler or Unmarshaler
+	// that transcode through BitWriter and BitReader.
+	BitOrder BitOrder
+
+	// Strict rejects data that decodes but does not conform to the
+	// structure definition, such as a structure ending part way through
+	// a byte or reserved bits that are not zero.
+	Strict bool
+
+	// Report, if not nil, records reserved bits decoded with a non-zero
+	// value when decoding is not Strict.
+	Report *Report
+
+	// Overflow selects how integer values that do not fit their field,
+	// such as 40 in a 5 bit field, are encoded. Signed values fit if
+	// within the range of the field in two's complement.
+	Overflow OverflowMode
+
+	// Reserved selects what is encoded in fields annotated as reserved.
+	Reserved ReservedMode
+
+	// ReservedFill is the byte pattern encoded by ReservedFill. Fields
+	// narrower than a byte take its low bits.
+	ReservedFill uint8
+
+	// MaxSliceLen limits the number of elements allocated for a slice
+	// while decoding, guarding against corrupt or hostile length fields.
+	// Zero is unlimited.
+	MaxSliceLen uint64
+}
+
+// endianness returns the default endianness described by the options,
+// consulting the environment for DefaultByteOrder.
+func (o *Options) endianness() (endian, error) {
+	switch o.ByteOrder {
+	case LittleEndian:
+		return little, nil
+	case BigEndian:
+		return big, nil
+	case DefaultByteOrder:
+		break
+	default:
+		return little, fmt.Errorf("Byte order %d not recognized", o.ByteOrder)
+	}
+
+	endianness, present := os.LookupEnv(EnvVarDefaultEndianness)
+	if !present {
+		return little, nil
+	}
+
+	switch strings.ToLower(endianness) {
+	case "little":
+		return little, nil
+	case "big":
+		return big, nil
+	}
+
+	return little, fmt.Errorf("Detected EnvVar %s: Endian '%s' not recognized. Should be one of 'big' or 'little'", EnvVarDefaultEndianness, endianness)
+}
+
+// bitOrder returns the default bit order described by the options.
+func (o *Options) bitOrder() (bitOrder, error) {
+	switch o.BitOrder {
+	case DefaultBitOrder, LSBFirst:
+		return lsbFirst, nil
+	case MSBFirst:
+		return msbFirst, nil
+	}
+
+	return lsbFirst, fmt.Errorf("Bit order %d not recognized", o.BitOrder)
+}
+
+// reservedValue returns the value encoded for a reserved field of nbits
+// holding value.
+func (o *Options) reservedValue(value uint64, nbits uint64) (uint64, error) {
+	switch o.Reserved {
+	case ReservedZero:
+		return 0, nil
+	case ReservedFill:
+		fill := uint64(o.ReservedFill) * 0x0101010101010101
+		if nbits < 64 {
+			fill &= 1<<nbits - 1
+		}
+		return fill, nil
+	case ReservedPreserve:
+		return value, nil
+	}
+
+	return 0, fmt.Errorf("Reserved mode %d not recognized", o.Reserved)
+}
+
+// fit returns the value encoded for a field of nbits holding value, the
+// bits of a signed integer if signed.
+func (o *Options) fit(value uint64, nbits uint64, signed bool) (uint64, error) {
+	if nbits >= 64 {
+		return value, nil
+	}
+
+	// The nearest value the field can hold
+	var nearest uint64
+
+	if signed {
+		lo, hi := int64(-1)<<(nbits-1), int64(1)<<(nbits-1)-1
+		switch v := int64(value); {
+		case v < lo:
+			nearest = uint64(lo)
+		case v > hi:
+			nearest = uint64(hi)
+		default:
+			return value, nil
+		}
+	} else {
+		nearest = 1<<nbits - 1
+		if value <= nearest {
+			return value, nil
+		}
+	}
+
+	switch o.Overflow {
+	case OverflowError:
+		if signed {
+			return 0, fmt.Errorf("Value %d will overflow signed bitfield of %d bits: %w", int64(value), nbits, ErrOverflow)
+		}
+		return 0, fmt.Errorf("Value %d (%#x) will overflow bitfield of %d bits: %w", value, value, nbits, ErrOverflow)
+	case OverflowTruncate:
+		return value, nil
+	case OverflowSaturate:
+		return nearest, nil
+	}
+
+	return 0, fmt.Errorf("Overflow mode %d not recognized", o.Overflow)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+type optionsStruct struct {
+	A uint16
+	B uint16 `little:""`
+	C uint8  `bitfield:"3"`
+	D uint8  `bitfield:"5"`
+}
+
+func encodeWithOptions(t *testing.T, s interface{}, opts Options) []byte {
+	var tw testWriter
+	if err := EncodeWithOptions(&tw, s, opts); err != nil {
+		t.Fatal(err)
+	}
+	return tw.getBytes(0, tw.getSize()-1)
+}
+
+func TestOptionsByteAndBitOrder(t *testing.T) {
+	s := optionsStruct{A: 0x1234, B: 0x1234, C: 5, D: 3}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected []byte
+	}{
+		{"Default", Options{}, []byte{0x34, 0x12, 0x34, 0x12, 0x1d}},
+		{"BigEndian", Options{ByteOrder: BigEndian}, []byte{0x12, 0x34, 0x34, 0x12, 0x1d}},
+		{"MSBFirst", Options{BitOrder: MSBFirst}, []byte{0x34, 0x12, 0x34, 0x12, 0xa3}},
+		{"Network", Options{ByteOrder: BigEndian, BitOrder: MSBFirst}, []byte{0x12, 0x34, 0x34, 0x12, 0xa3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := encodeWithOptions(t, s, test.opts); !bytes.Equal(actual, test.expected) {
+				t.Errorf("Invalid encoding: Expected: %x Actual: %x", test.expected, actual)
+			}
+
+			var actual optionsStruct
+			if err := DecodeWithOptions(newReader(test.expected), &actual, test.opts); err != nil {
+				t.Fatal(err)
+			}
+			if actual != s {
+				t.Errorf("Invalid decoding: Expected: %+v Actual: %+v", s, actual)
+			}
+		})
+	}
+}
+
+func TestOptionsEnvironmentFallback(t *testing.T) {
+	defer os.Unsetenv(EnvVarDefaultEndianness)
+
+	s := struct{ A uint16 }{0x1234}
+
+	os.Setenv(EnvVarDefaultEndianness, "big")
+	if actual := encodeWithOptions(t, s, Options{}); !bytes.Equal(actual, []byte{0x12, 0x34}) {
+		t.Errorf("Environment default not applied: %x", actual)
+	}
+	if actual := encodeWithOptions(t, s, Options{ByteOrder: LittleEndian}); !bytes.Equal(actual, []byte{0x34, 0x12}) {
+		t.Errorf("Options do not take precedence over the environment: %x", actual)
+	}
+
+	os.Setenv(EnvVarDefaultEndianness, "middle")
+	if err := Encode(&testWriter{}, s); err == nil {
+		t.Error("Expected error for unrecognized environment value")
+	}
+	if _, err := Size(s); err == nil {
+		t.Error("Expected error for unrecognized environment value")
+	}
+	if _, err := SizeWithOptions(s, Options{ByteOrder: BigEndian}); err != nil {
+		t.Errorf("Environment consulted despite explicit byte order: %v", err)
+	}
+}
+
+func TestOptionsInvalid(t *testing.T) {
+	s := struct{ A uint8 }{}
+
+	for _, opts := range []Options{{ByteOrder: 7}, {BitOrder: 7}} {
+		if _, err := SizeWithOptions(s, opts); err == nil {
+			t.Errorf("Expected error for invalid options %+v", opts)
+		}
+	}
+}
+
+func TestOptionsStrict(t *testing.T) {
+	type s struct {
+		A uint8 `bitfield:"4"`
+	}
+
+	if err := DecodeWithOptions(newReader([]byte{0x0f}), new(s), Options{}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := DecodeWithOptions(newReader([]byte{0x0f}), new(s), Options{Strict: true}); err == nil {
+		t.Error("Expected strict error for left-over bits")
+	}
+}
+
+func TestOptionsMaxSliceLen(t *testing.T) {
+	type s struct {
+		N uint8 `countOf:"D"`
+		D []uint8
+	}
+
+	b := []byte{4, 1, 2, 3, 4}
+
+	if err := DecodeWithOptions(newReader(b), new(s), Options{MaxSliceLen: 4}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := DecodeWithOptions(newReader(b), new(s), Options{MaxSliceLen: 3}); err == nil {
+		t.Error("Expected error for slice exceeding limit")
+	}
+}
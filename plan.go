@@ -0,0 +1,269 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A structPlan is the compiled layout of a structure type. Plans are built
+// once per reflect.Type, cached, and shared by the encoder, decoder and sizer
+// so the structure tags are only parsed the first time a type is seen.
+type structPlan struct {
+	typ       reflect.Type
+	fields    []fieldPlan
+	names     map[string]int
+	order     bitOrder // Bit order declared by a blank `_` field, if any
+	checksums []int    // Indices of fields annotated with checksum, in order
+}
+
+// A fieldPlan describes a single structure field within a structPlan. The
+// tags are read-only once the plan is published; any per-call state must
+// be kept by the transcoder.
+type fieldPlan struct {
+	index    int
+	name     string
+	kind     reflect.Kind
+	tags     tags
+	custom   bool       // Field type implements Marshaler, Unmarshaler or Sizer
+	union    *fieldPlan // Union switching on the field, if a discriminator
+	optional bool       // Pointer field absent at the end of the data if nil
+}
+
+type planEntry struct {
+	plan *structPlan
+	err  error
+}
+
+var plans sync.Map // map[reflect.Type]*planEntry
+
+// planOf returns the compiled plan for the structure type typ. Tagging
+// errors are reported when the plan is first built, and again on every
+// subsequent lookup of the same type.
+func planOf(typ reflect.Type) (*structPlan, error) {
+	return loadPlan(typ, make(map[reflect.Type]bool))
+}
+
+func loadPlan(typ reflect.Type, seen map[reflect.Type]bool) (*structPlan, error) {
+	if e, ok := plans.Load(typ); ok {
+		entry := e.(*planEntry)
+		return entry.plan, entry.err
+	}
+
+	p, err := compilePlan(typ, seen)
+
+	e, _ := plans.LoadOrStore(typ, &planEntry{plan: p, err: err})
+	entry := e.(*planEntry)
+	return entry.plan, entry.err
+}
+
+func compilePlan(typ reflect.Type, seen map[reflect.Type]bool) (*structPlan, error) {
+	seen[typ] = true
+
+	p := &structPlan{
+		typ:    typ,
+		fields: make([]fieldPlan, typ.NumField()),
+		names:  make(map[string]int, typ.NumField()),
+		order:  orderUndefined,
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+
+		tags, err := parseFieldTags(sf)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typ.Name(), sf.Name, err)
+		}
+
+		p.fields[i] = fieldPlan{
+			index:  i,
+			name:   sf.Name,
+			kind:   sf.Type.Kind(),
+			tags:   tags,
+			custom: isCustom(sf.Type),
+		}
+		p.names[sf.Name] = i
+
+		// A blank field declares the bit order of the whole structure,
+		// i.e. _ struct{} `bitorder:"msb"`
+		if sf.Name == "_" && tags.bitOrder != orderUndefined {
+			p.order = tags.bitOrder
+		}
+
+		// Compile any nested structures now so tagging errors are found
+		// before a single byte is transcoded. Structures transcoding
+		// themselves are left alone.
+		nested := elemType(sf.Type)
+		for nested.Kind() == reflect.Ptr {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && !isCustom(nested) && !seen[nested] {
+			if _, err := loadPlan(nested, seen); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Trailing pointer fields are optional, so records may be extended by
+	// later revisions of a format.
+	for i := len(p.fields); i != 0 && p.fields[i-1].kind == reflect.Ptr; i-- {
+		p.fields[i-1].optional = true
+	}
+
+	// Layouts referencing a field of the same structure can be checked now;
+	// references to fields of enclosing structures are resolved at runtime.
+	for i := range p.fields {
+		l := &p.fields[i].tags.layout
+		if l.format == none {
+			continue
+		}
+		if k := p.fields[i].kind; !isInteger(k) && k != reflect.Bool {
+			return nil, fmt.Errorf("%s.%s: layout field must be of integer type; is of type %s: %w",
+				typ.Name(), p.fields[i].name, k.String(), ErrTag)
+		}
+		if l.name == restOf {
+			if l.format != sizeOf {
+				return nil, fmt.Errorf("%s.%s: remainder of the structure can only be given by sizeOf: %w",
+					typ.Name(), p.fields[i].name, ErrTag)
+			}
+			continue
+		}
+		if idx, ok := p.names[l.name]; ok {
+			if k := p.fields[idx].kind; !isLayoutTarget(k, l.format) {
+				return nil, fmt.Errorf("%s.%s: referenced layout must be of type slice or array; is of type %s: %w",
+					typ.Name(), p.fields[i].name, k.String(), ErrTag)
+			}
+		}
+
+		// Structures are sized from the end of the sizeOf field
+		if idx, ok := p.names[l.name]; ok && !isArray(p.fields[idx].kind) && idx <= i {
+			return nil, fmt.Errorf("%s.%s: sizeOf must precede the structure '%s': %w",
+				typ.Name(), p.fields[i].name, l.name, ErrTag)
+		}
+
+		// Bytes counted from an origin are sized up to the referenced field
+		if idx, ok := p.names[l.name]; l.origin != originNone && (!ok || idx <= i) {
+			return nil, fmt.Errorf("%s.%s: sizeOf from an origin must precede '%s' in the same structure: %w",
+				typ.Name(), p.fields[i].name, l.name, ErrTag)
+		}
+	}
+
+	// Greedy slices run to the end of the input, so nothing can follow
+	// them. Neither they nor slices ending with a terminator can have their
+	// length given by a layout.
+	for i := range p.fields {
+		ft := &p.fields[i].tags
+		if !ft.greedy && ft.sentinel == nil {
+			continue
+		}
+		if ft.greedy && i != len(p.fields)-1 {
+			return nil, fmt.Errorf("%s.%s: greedy slice must be the last field: %w",
+				typ.Name(), p.fields[i].name, ErrTag)
+		}
+		for j := range p.fields {
+			if p.fields[j].tags.layout.name == p.fields[i].name {
+				return nil, fmt.Errorf("%s.%s: slice ending on its own cannot also be given by '%s': %w",
+					typ.Name(), p.fields[i].name, p.fields[j].name, ErrTag)
+			}
+		}
+	}
+
+	// Conditions testing a field of the same structure must follow it, so
+	// it has been transcoded when the condition is evaluated
+	for i := range p.fields {
+		c := p.fields[i].tags.cond
+		if c == nil {
+			continue
+		}
+		if idx, ok := p.names[c.path[0]]; ok && idx >= i {
+			return nil, fmt.Errorf("%s.%s: condition must follow the referenced field '%s': %w",
+				typ.Name(), p.fields[i].name, c.path[0], ErrTag)
+		}
+	}
+
+	// Offsets held by a field of the same structure must be read first
+	for i := range p.fields {
+		name := p.fields[i].tags.offset.name
+		if len(name) == 0 {
+			continue
+		}
+		if idx, ok := p.names[name]; ok && (idx >= i || !isInteger(p.fields[idx].kind)) {
+			return nil, fmt.Errorf("%s.%s: offset must follow the integer field '%s': %w",
+				typ.Name(), p.fields[i].name, name, ErrTag)
+		}
+	}
+
+	// Unions switch on a preceding integer field of the same structure,
+	// which the encoder writes from the case held by the union.
+	for i := range p.fields {
+		name := p.fields[i].tags.union
+		if len(name) == 0 {
+			continue
+		}
+
+		idx, ok := p.names[name]
+		if !ok || idx >= i || !isInteger(p.fields[idx].kind) {
+			return nil, fmt.Errorf("%s.%s: union must follow the integer field '%s' of the same structure: %w",
+				typ.Name(), p.fields[i].name, name, ErrTag)
+		}
+		if p.fields[idx].union != nil {
+			return nil, fmt.Errorf("%s.%s: field '%s' already selects the union %s: %w",
+				typ.Name(), p.fields[i].name, name, p.fields[idx].union.name, ErrTag)
+		}
+
+		p.fields[idx].union = &p.fields[i]
+	}
+
+	// Checksum ranges are bounded by fields of the same structure
+	for i := range p.fields {
+		c := &p.fields[i].tags.checksum
+		if c.fn == nil {
+			continue
+		}
+
+		from, to := 0, len(p.fields)-1
+		ok := true
+		if len(c.from) != 0 {
+			from, ok = p.names[c.from]
+		}
+		if len(c.to) != 0 && ok {
+			to, ok = p.names[c.to]
+		}
+		if !ok || from > to {
+			return nil, fmt.Errorf("%s.%s: checksum range %s to %s is not within the structure: %w",
+				typ.Name(), p.fields[i].name, c.from, c.to, ErrTag)
+		}
+
+		p.checksums = append(p.checksums, i)
+	}
+
+	return p, nil
+}
+
+// fieldByName returns the index of the named field within the plan.
+func (p *structPlan) fieldByName(name string) (int, bool) {
+	idx, ok := p.names[name]
+	return idx, ok
+}
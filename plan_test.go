@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPlanCache(t *testing.T) {
+	type ts struct {
+		A uint8 `bitfield:"4"`
+		B uint8 `bitfield:"4"`
+		C uint16
+	}
+
+	p1, err := planOf(reflect.TypeOf(ts{}))
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	p2, err := planOf(reflect.TypeOf(ts{}))
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if p1 != p2 {
+		t.Errorf("Expected cached plan to be reused")
+	}
+
+	if len(p1.fields) != 3 {
+		t.Fatalf("Invalid number of planned fields: Expected: %d Actual: %d", 3, len(p1.fields))
+	}
+
+	if p1.fields[0].tags.bitfield.nbits != 4 || p1.fields[2].tags.bitfield.nbits != 16 {
+		t.Errorf("Invalid planned bitfields: %+v", p1.fields)
+	}
+}
+
+func TestPlanTaggingError(t *testing.T) {
+	type nested struct {
+		A uint8 `bitfield:"x"`
+	}
+
+	type ts struct {
+		N nested
+	}
+
+	err := Decode(newReader([]byte{0}), new(ts))
+
+	var terr *TaggingError
+	if !errors.As(err, &terr) {
+		t.Errorf("Expected tagging error: Actual: %v", err)
+	}
+
+	if _, err := Size(ts{}); err == nil {
+		t.Errorf("Expected tagging error from Size")
+	}
+}
+
+func TestPlanFieldTypes(t *testing.T) {
+	type zero struct {
+		A uint8 `bitfield:"0"`
+		B uint8
+	}
+	type wide struct {
+		A uint8 `bitfield:"12"`
+		B uint8 `bitfield:"4"`
+	}
+	type unsupported struct {
+		A uint8
+		M map[string]int
+	}
+
+	for _, v := range []interface{}{zero{}, wide{}, unsupported{}} {
+		if _, err := planOf(reflect.TypeOf(v)); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", v, err)
+		}
+
+		if err := Encode(bytes.NewBuffer(nil), v); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error encoding %T: Actual: %v", v, err)
+		}
+	}
+}
+
+func TestPlanLayoutReference(t *testing.T) {
+	type ts struct {
+		Count uint8 `countOf:"Value"`
+		Value uint8
+	}
+
+	if _, err := planOf(reflect.TypeOf(ts{})); err == nil {
+		t.Errorf("Expected layout reference error")
+	}
+}
+
+func TestPlanLayoutType(t *testing.T) {
+	type ts struct {
+		L string `sizeOf:"D" string:"2"`
+		D []uint8
+	}
+
+	if _, err := planOf(reflect.TypeOf(ts{})); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected layout type error: Actual: %v", err)
+	}
+
+	if err := Encode(bytes.NewBuffer(nil), ts{D: []uint8{1, 2}}); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected layout type error encoding: Actual: %v", err)
+	}
+}
+
+func TestPlanConditionReference(t *testing.T) {
+	type ts struct {
+		Value uint8 `if:"Flags==1"`
+		Flags uint8
+	}
+
+	if _, err := planOf(reflect.TypeOf(ts{})); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected condition reference error: Actual: %v", err)
+	}
+
+	if err := Decode(newReader([]byte{1, 1}), new(ts)); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected condition reference error decoding: Actual: %v", err)
+	}
+}
+
+func TestPlanConcurrent(t *testing.T) {
+	type as struct {
+		A uint8 `bitfield:"4"`
+		B uint8 `bitfield:"4"`
+	}
+
+	type ts struct {
+		Count uint8 `countOf:"As"`
+		As    []as
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s := new(ts)
+			if err := DecodeByteBuffer(bytes.NewBuffer([]byte{2, 0x21, 0x43}), s); err != nil {
+				t.Error(err)
+				return
+			}
+
+			if len(s.As) != 2 || s.As[1].A != 3 || s.As[1].B != 4 {
+				t.Errorf("Invalid decode: %+v", s)
+			}
+		}()
+	}
+	wg.Wait()
+}
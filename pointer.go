@@ -0,0 +1,85 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// pointee returns the value encoded for the pointer val, or false if the
+// pointer is nil and optional. Nil pointers that are not optional encode
+// the zero value so the layout of the structure is unchanged.
+func pointee(val reflect.Value, elem *fieldPlan) (reflect.Value, bool) {
+	if !val.IsNil() {
+		return val.Elem(), true
+	}
+
+	if elem.optional {
+		return reflect.Value{}, false
+	}
+
+	return reflect.New(val.Type().Elem()).Elem(), true
+}
+
+func (e *encoder) pointer(t *transcoder, val reflect.Value, elem *fieldPlan) error {
+	if v, ok := pointee(val, elem); ok {
+		return t.transcodeField(v, elem)
+	}
+	return nil
+}
+
+func (s *sizer) pointer(t *transcoder, val reflect.Value, elem *fieldPlan) error {
+	if v, ok := pointee(val, elem); ok {
+		return t.transcodeField(v, elem)
+	}
+	return nil
+}
+
+// pointer decodes into the value pointed to by val, allocating it if nil.
+// Optional fields left without any data are set to nil.
+func (d *decoder) pointer(t *transcoder, val reflect.Value, elem *fieldPlan) error {
+	if !val.CanSet() {
+		return fmt.Errorf("Field of type %s cannot be set. Make sure it is exported.", val.Type().String())
+	}
+
+	ptr := val
+	if ptr.IsNil() {
+		ptr = reflect.New(val.Type().Elem())
+	}
+
+	offset, bit := d.position()
+
+	if err := t.transcodeField(ptr.Elem(), elem); err != nil {
+		if end, _ := d.position(); elem.optional && bit == 0 && end == offset && errors.Is(err, io.EOF) {
+			val.Set(reflect.Zero(val.Type()))
+			return nil
+		}
+		return err
+	}
+
+	val.Set(ptr)
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type ptrHeader struct {
+	Length uint16
+}
+
+type ptrRecord struct {
+	Version  uint8
+	Header   *ptrHeader
+	Count    *uint16    `big:""`
+	Flags    *uint8     `bitfield:"4"`
+	Mode     *uint8     `bitfield:"4"`
+	Ext      *ptrHeader `if:"Version>=2"`
+	Reserved uint8
+	Tail     *uint32
+	Next     *ptrHeader
+}
+
+type ptrNode struct {
+	Value uint8
+	Next  *ptrNode
+}
+
+func ptrUint8(v uint8) *uint8    { return &v }
+func ptrUint16(v uint16) *uint16 { return &v }
+func ptrUint32(v uint32) *uint32 { return &v }
+
+func TestPointerField(t *testing.T) {
+	tests := []struct {
+		s   ptrRecord
+		b   []byte
+		dec ptrRecord
+	}{
+		{
+			ptrRecord{Version: 2, Header: &ptrHeader{0x1234}, Count: ptrUint16(5), Flags: ptrUint8(3), Mode: ptrUint8(0xA),
+				Ext: &ptrHeader{0x5678}, Tail: ptrUint32(1), Next: &ptrHeader{2}},
+			[]byte{0x02, 0x34, 0x12, 0x00, 0x05, 0xA3, 0x78, 0x56, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00},
+			ptrRecord{Version: 2, Header: &ptrHeader{0x1234}, Count: ptrUint16(5), Flags: ptrUint8(3), Mode: ptrUint8(0xA),
+				Ext: &ptrHeader{0x5678}, Tail: ptrUint32(1), Next: &ptrHeader{2}},
+		},
+		{
+			// Nil pointers encode their zero value, unless optional
+			ptrRecord{Version: 1, Ext: &ptrHeader{0x5678}},
+			[]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			ptrRecord{Version: 1, Header: &ptrHeader{}, Count: ptrUint16(0), Flags: ptrUint8(0), Mode: ptrUint8(0)},
+		},
+		{
+			ptrRecord{Version: 1, Header: &ptrHeader{1}, Tail: ptrUint32(0x01020304)},
+			[]byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x03, 0x02, 0x01},
+			ptrRecord{Version: 1, Header: &ptrHeader{1}, Count: ptrUint16(0), Flags: ptrUint8(0), Mode: ptrUint8(0), Tail: ptrUint32(0x01020304)},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := EncodeByteBuffer(test.s)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		if !bytes.Equal(b, test.b) {
+			t.Errorf("Invalid pointer encoding:\nExpected: %#02x\nActual:   %#02x", test.b, b)
+		}
+
+		if sz, err := Size(test.s); err != nil || sz != uint64(len(test.b)) {
+			t.Errorf("Invalid pointer size: Expected: %d Actual: %d %v", len(test.b), sz, err)
+		}
+
+		var s ptrRecord
+		if err := Decode(bytes.NewReader(test.b), &s); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if !reflect.DeepEqual(s, test.dec) {
+			t.Errorf("Invalid pointer decode:\nExpected: %+v\nActual:   %+v", test.dec, s)
+		}
+	}
+
+	// Optional fields with only some of their data are truncated
+	b := []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x03}
+	if err := Decode(bytes.NewReader(b), new(ptrRecord)); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected short buffer decoding truncated Tail: Actual: %v", err)
+	}
+
+	if errs := Validate(ptrRecord{}); len(errs) != 0 {
+		t.Errorf("Unexpected validation errors: %v", errs)
+	}
+}
+
+func TestPointerList(t *testing.T) {
+	list := ptrNode{1, &ptrNode{2, &ptrNode{3, nil}}}
+	expected := []byte{0x01, 0x02, 0x03}
+
+	b, err := EncodeByteBuffer(list)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Invalid list encoding:\nExpected: %#02x\nActual:   %#02x", expected, b)
+	}
+
+	var s ptrNode
+	if err := Decode(bytes.NewReader(b), &s); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(s, list) {
+		t.Errorf("Invalid list decode: %+v", s)
+	}
+}
+
+func TestPointerTaggingError(t *testing.T) {
+	s := struct {
+		A uint8
+		C *uint8 `checksum:"sum8"`
+	}{}
+
+	if _, err := Size(s); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected tagging error for checksum pointer: Actual: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+// A Report records data that decoded successfully but does not conform to
+// the structure definition. Request one by setting Options.Report.
+type Report struct {
+	Reserved []ReservedViolation
+}
+
+// A ReservedViolation describes reserved bits decoded with a non-zero value.
+type ReservedViolation struct {
+	// Path of the field within the top level structure, i.e.
+	// Descriptors[3].Flags
+	Path string
+
+	// Offset is the byte offset of the field from the start of the
+	// stream, and Bit the offset in bits of the field within that byte.
+	Offset uint64
+	Bit    uint64
+
+	// Value of the reserved bits.
+	Value uint64
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type reservedDescriptor struct {
+	Type  uint8 `bitfield:"4"`
+	Flags uint8 `bitfield:"4,reserved"`
+}
+
+type reservedStruct struct {
+	Version     uint8
+	Rsvd        [2]uint8 `bitfield:"8,reserved"`
+	Count       uint8    `countOf:"Descriptors"`
+	Descriptors []reservedDescriptor
+}
+
+var reservedBytes = []byte{
+	1,
+	0, 0xAA,
+	3,
+	0x01, 0x02, 0x53,
+}
+
+func TestReservedLenient(t *testing.T) {
+	var s reservedStruct
+	if err := Decode(bytes.NewReader(reservedBytes), &s); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	if s.Rsvd[1] != 0xAA || s.Descriptors[2].Flags != 5 {
+		t.Errorf("Expected reserved values to be decoded: %+v", s)
+	}
+}
+
+func TestReservedStrict(t *testing.T) {
+	var s reservedStruct
+	err := DecodeWithOptions(bytes.NewReader(reservedBytes), &s, Options{Strict: true})
+
+	if !errors.Is(err, ErrReserved) {
+		t.Fatalf("Expected reserved error: Actual: %v", err)
+	}
+
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Path != "Rsvd[1]" || fe.Offset != 2 || fe.Bit != 0 {
+		t.Errorf("Unexpected field error: %v", err)
+	}
+
+	b := []byte{1, 0, 0, 1, 0x31}
+	err = DecodeWithOptions(bytes.NewReader(b), &s, Options{Strict: true})
+
+	if !errors.As(err, &fe) || fe.Path != "Descriptors[0].Flags" || fe.Offset != 4 || fe.Bit != 4 {
+		t.Errorf("Unexpected field error: %v", err)
+	}
+}
+
+func TestReservedReport(t *testing.T) {
+	var r Report
+	var s reservedStruct
+
+	if err := DecodeWithOptions(bytes.NewReader(reservedBytes), &s, Options{Report: &r}); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	expected := []ReservedViolation{
+		{Path: "Rsvd[1]", Offset: 2, Bit: 0, Value: 0xAA},
+		{Path: "Descriptors[2].Flags", Offset: 6, Bit: 4, Value: 5},
+	}
+
+	if !reflect.DeepEqual(r.Reserved, expected) {
+		t.Errorf("Unexpected report: Expected: %+v Actual: %+v", expected, r.Reserved)
+	}
+}
+
+func TestReservedEncode(t *testing.T) {
+	s := reservedStruct{
+		Version: 1,
+		Rsvd:    [2]uint8{0x12, 0xAA},
+		Descriptors: []reservedDescriptor{
+			{Type: 1, Flags: 0},
+			{Type: 2, Flags: 0},
+			{Type: 3, Flags: 5},
+		},
+	}
+
+	tests := []struct {
+		opts     Options
+		expected []byte
+	}{
+		{Options{}, []byte{1, 0, 0, 3, 0x01, 0x02, 0x03}},
+		{Options{Reserved: ReservedFill, ReservedFill: 0xFF}, []byte{1, 0xFF, 0xFF, 3, 0xF1, 0xF2, 0xF3}},
+		{Options{Reserved: ReservedFill, ReservedFill: 0xA5}, []byte{1, 0xA5, 0xA5, 3, 0x51, 0x52, 0x53}},
+		{Options{Reserved: ReservedPreserve}, []byte{1, 0x12, 0xAA, 3, 0x01, 0x02, 0x53}},
+	}
+
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := EncodeWithOptions(&b, s, test.opts); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+
+		if !bytes.Equal(b.Bytes(), test.expected) {
+			t.Errorf("Unexpected encoding for %+v: Expected: %#v Actual: %#v", test.opts, test.expected, b.Bytes())
+		}
+	}
+
+	if err := EncodeWithOptions(new(bytes.Buffer), s, Options{Reserved: ReservedMode(-1)}); err == nil {
+		t.Errorf("Expected error for invalid reserved mode")
+	}
+}
+
+func TestReservedRoundTrip(t *testing.T) {
+	var s reservedStruct
+	if err := Decode(bytes.NewReader(reservedBytes), &s); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := EncodeWithOptions(&b, s, Options{Reserved: ReservedPreserve}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	if !bytes.Equal(b.Bytes(), reservedBytes) {
+		t.Errorf("Reserved contents not preserved: Expected: %#v Actual: %#v", reservedBytes, b.Bytes())
+	}
+}
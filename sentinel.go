@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// sentinel is the element ending a list annotated with
+// `until:"Type==127"` or `terminator:"0"`.
+type sentinel struct {
+	cond    *condition    // Condition on the fields of the terminating element
+	value   reflect.Value // Terminating element, if not given by cond
+	include bool          // Terminator is kept as the last element of the slice
+}
+
+// parseSentinel parses the until or terminator annotation of a slice with
+// elements of type typ.
+func parseSentinel(key string, val string, typ reflect.Type) (*sentinel, bool) {
+	opts := strings.Split(val, ",")
+	s := &sentinel{}
+
+	for _, opt := range opts[1:] {
+		if strings.TrimSpace(opt) != "include" {
+			return nil, false
+		}
+		s.include = true
+	}
+
+	if key == "until" {
+		c, ok := parseCondition(opts[0])
+		if !ok || !hasField(typ, c.path) {
+			return nil, false
+		}
+		s.cond = c
+		return s, true
+	}
+
+	s.value = reflect.New(typ).Elem()
+
+	// Structures are terminated by an element of all zeros
+	switch typ.Kind() {
+	case reflect.Struct, reflect.Array:
+		return s, strings.TrimSpace(opts[0]) == "0"
+	}
+
+	c, ok := parseConstant(strings.TrimSpace(opts[0]), typ)
+	if !ok {
+		return nil, false
+	}
+	setBits(s.value, c.value)
+
+	return s, true
+}
+
+// hasField reports if path names an integer or bool field of the
+// structure typ, or of the structures nested within it.
+func hasField(typ reflect.Type, path []string) bool {
+	for _, name := range path {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return false
+		}
+
+		sf, ok := typ.FieldByName(name)
+		if !ok {
+			return false
+		}
+		typ = sf.Type
+	}
+
+	return isInteger(typ.Kind()) || typ.Kind() == reflect.Bool
+}
+
+// setBits sets the integer or bool value v to bits.
+func setBits(v reflect.Value, bits uint64) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(bits != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(bits))
+	default:
+		v.SetUint(bits)
+	}
+}
+
+// matches reports if elem is the terminating element.
+func (s *sentinel) matches(elem reflect.Value) (bool, error) {
+	if s.cond != nil {
+		return s.cond.holds(elem, s.cond.path)
+	}
+
+	switch elem.Kind() {
+	case reflect.Struct, reflect.Array:
+		return elem.IsZero(), nil
+	}
+
+	return elem.Interface() == s.value.Interface(), nil
+}
+
+// terminator returns the terminating element of a slice of typ. Elements
+// ending an until list are built by setting the referenced field to the
+// operand of the condition, which must then hold.
+func (s *sentinel) terminator(typ reflect.Type) (reflect.Value, error) {
+	if s.cond == nil {
+		return s.value, nil
+	}
+
+	elem := reflect.New(typ).Elem()
+
+	f := elem
+	for _, name := range s.cond.path {
+		for f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			f = f.Elem()
+		}
+		f = f.FieldByName(name)
+	}
+
+	switch s.cond.op {
+	case "==", "<=", ">=", "&":
+		setBits(f, s.cond.operand())
+	case "":
+		if !s.cond.negate {
+			setBits(f, 1)
+		}
+	}
+
+	if ok, err := s.matches(elem); err != nil || !ok {
+		return elem, fmt.Errorf("Cannot build terminating element of %s: %w", typ.String(), ErrTag)
+	}
+
+	return elem, nil
+}
+
+// terminated returns the slice arr ending with its terminating element,
+// appended to a copy of arr if not already present.
+func (s *sentinel) terminated(arr reflect.Value) (reflect.Value, error) {
+	n := arr.Len()
+	if n != 0 {
+		if ok, err := s.matches(arr.Index(n - 1)); err != nil || ok {
+			return arr, err
+		}
+	}
+
+	term, err := s.terminator(arr.Type().Elem())
+	if err != nil {
+		return arr, err
+	}
+
+	out := reflect.MakeSlice(arr.Type(), n, n+1)
+	reflect.Copy(out, arr)
+
+	return reflect.Append(out, term), nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type sentinelHeader struct {
+	Type   uint8
+	Length uint8
+}
+
+type sentinelTable struct {
+	Count   uint8
+	Headers []sentinelHeader `until:"Type==127,include"`
+}
+
+type sentinelList struct {
+	Entries []sentinelHeader `terminator:"0"`
+	Codes   []uint8          `terminator:"0xFF"`
+	Trailer uint16
+}
+
+func TestSentinelList(t *testing.T) {
+	tests := []struct {
+		s   interface{}
+		b   []byte
+		dec interface{}
+	}{
+		{
+			&sentinelTable{Count: 2, Headers: []sentinelHeader{{0, 24}, {1, 27}}},
+			[]byte{0x02, 0x00, 0x18, 0x01, 0x1B, 0x7F, 0x00},
+			&sentinelTable{Count: 2, Headers: []sentinelHeader{{0, 24}, {1, 27}, {127, 0}}},
+		},
+		{
+			&sentinelTable{Count: 1, Headers: []sentinelHeader{{0, 24}, {127, 4}}},
+			[]byte{0x01, 0x00, 0x18, 0x7F, 0x04},
+			&sentinelTable{Count: 1, Headers: []sentinelHeader{{0, 24}, {127, 4}}},
+		},
+		{
+			&sentinelList{Entries: []sentinelHeader{{1, 2}, {3, 4}}, Codes: []uint8{5, 6}, Trailer: 0x0201},
+			[]byte{0x01, 0x02, 0x03, 0x04, 0x00, 0x00, 0x05, 0x06, 0xFF, 0x01, 0x02},
+			&sentinelList{Entries: []sentinelHeader{{1, 2}, {3, 4}}, Codes: []uint8{5, 6}, Trailer: 0x0201},
+		},
+		{
+			&sentinelList{Entries: []sentinelHeader{}, Codes: []uint8{}},
+			[]byte{0x00, 0x00, 0xFF, 0x00, 0x00},
+			&sentinelList{Entries: []sentinelHeader{}, Codes: []uint8{}},
+		},
+	}
+
+	for _, test := range tests {
+		b := new(bytes.Buffer)
+		if err := Encode(b, test.s); err != nil {
+			t.Fatalf("Encode %T failed: %v", test.s, err)
+		}
+		if !bytes.Equal(b.Bytes(), test.b) {
+			t.Errorf("Invalid %T encoding:\nExpected: %#02x\nActual:   %#02x", test.s, test.b, b.Bytes())
+		}
+
+		if sz, err := Size(test.s); err != nil || sz != uint64(len(test.b)) {
+			t.Errorf("Invalid %T size: Expected: %d Actual: %d %v", test.s, len(test.b), sz, err)
+		}
+
+		s := reflect.New(reflect.TypeOf(test.s).Elem())
+		if err := Decode(bytes.NewReader(test.b), s.Interface()); err != nil {
+			t.Fatalf("Decode %T failed: %v", test.s, err)
+		}
+		if !reflect.DeepEqual(s.Interface(), test.dec) {
+			t.Errorf("Invalid %T decode:\nExpected: %+v\nActual:   %+v", test.s, test.dec, s.Interface())
+		}
+
+		// Decoded lists encode the same, whether or not the terminator is kept
+		if b, err := EncodeByteBuffer(test.dec); err != nil || !bytes.Equal(b, test.b) {
+			t.Errorf("Invalid %T encoding of decoded value: %#02x %v", test.s, b, err)
+		}
+	}
+}
+
+func TestSentinelListError(t *testing.T) {
+	// Input ending before the terminator
+	b := []byte{0x02, 0x00, 0x18, 0x01, 0x1B}
+	if err := Decode(bytes.NewReader(b), new(sentinelTable)); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected short buffer decoding unterminated list: Actual: %v", err)
+	}
+
+	// No element can be built to satisfy the condition
+	s := struct {
+		Headers []sentinelHeader `until:"Type>127"`
+	}{}
+	if err := Encode(new(bytes.Buffer), s); !errors.Is(err, ErrTag) {
+		t.Errorf("Expected tagging error encoding terminator: Actual: %v", err)
+	}
+
+	tests := []interface{}{
+		struct {
+			A []uint8 `until:"Type==127"`
+		}{},
+		struct {
+			A []sentinelHeader `until:"Missing==1"`
+		}{},
+		struct {
+			A []sentinelHeader `terminator:"1"`
+		}{},
+		struct {
+			A []uint8 `terminator:"0x100"`
+		}{},
+		struct {
+			A []uint8 `terminator:"0,exclude"`
+		}{},
+		struct {
+			A [4]uint8 `terminator:"0"`
+		}{},
+		struct {
+			N uint8   `countOf:"A"`
+			A []uint8 `terminator:"0"`
+		}{},
+	}
+
+	for _, test := range tests {
+		if _, err := Size(test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+	}
+}
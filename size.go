@@ -33,9 +33,10 @@ var (
 )
 
 type sizer struct {
-	size   uint64
-	nbits  uint64
-	nbytes uint64
+	size       uint64
+	nbits      uint64
+	nbytes     uint64
+	transcoder *transcoder
 }
 
 func (s *sizer) addBits(nbits uint64) error {
@@ -47,6 +48,10 @@ func (s *sizer) addBits(nbits uint64) error {
 	return nil
 }
 
+func (s *sizer) position() (uint64, uint64) {
+	return s.nbytes, s.nbits
+}
+
 func (s *sizer) align(val alignment) error {
 	if s.nbits != 0 {
 		if err := s.addBits(8 - s.nbits); err != nil {
@@ -64,54 +69,60 @@ func (s *sizer) align(val alignment) error {
 }
 
 func (s *sizer) field(val reflect.Value, tags *tags) error {
-	if tags == nil {
-		return s.addBits(uint64(val.Type().Bits()))
+	if tags != nil && tags.bitfield.nbits != 0 {
+		return s.addBits(tags.bitfield.nbits)
 	}
-	return s.addBits(tags.bitfield.nbits)
+	if val.Kind() == reflect.Bool {
+		return s.addBits(1)
+	}
+	return s.addBits(uint64(val.Type().Bits()))
 }
 
 func (s *sizer) layout(val reflect.Value, ref *tagReference) error {
-	value := uint64(0)
-
-	if ref.value.IsZero() {
-		switch ref.tags.layout.format {
-		case sizeOf:
-			sz, err := size(val.Index(0))
-			if err != nil {
-				return err
-			}
-
-			value = uint64(val.Len()) * sz
-			if ref.tags.layout.relative {
-				value -= s.nbytes
-			}
-		case countOf:
-			value = uint64(val.Len())
-		}
-	} else {
-		value = getValue(ref.value)
+	value, err := s.transcoder.layoutValue(val, ref, s.nbytes, s.nbits)
+	if err != nil {
+		return err
 	}
 
-	ref.tags.layout.value = value
+	ref.layoutValue = value
 
 	return s.addBits(ref.tags.bitfield.nbits)
 }
 
 func (s *sizer) array(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error {
-	sz, err := size(arr.Index(0))
-	if err != nil {
-		return err
+	if arr.Len() == 0 {
+		return nil
 	}
 
-	len := uint64(arr.Len())
-	if ref != nil && !ref.value.IsZero() {
-		len = ref.value.Uint()
+	n := t.elements(arr, tags, ref, s.nbytes)
+
+	// Elements of basic kind are encoded with the field's own width,
+	// which may differ from the in-memory size (e.g. half floats).
+	if nbits, ok := elemBits(arr.Type(), tags); ok {
+		return s.addBits(nbits * n)
 	}
 
-	return s.addBits(sz * len * 8)
+	// Elements may differ in size, i.e. with conditional fields or unions,
+	// and may reference fields of the enclosing structures.
+	for i := 0; i < int(n); i++ {
+		offset, bit := s.position()
+
+		if err := t.transcode(arr.Index(i), tags); err != nil {
+			return elementError(err, i, offset, bit)
+		}
+	}
+
+	return nil
 }
 
 func (s *sizer) slice(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error {
+	if tags != nil && tags.sentinel != nil {
+		var err error
+		if arr, err = tags.sentinel.terminated(arr); err != nil {
+			return err
+		}
+	}
+
 	return s.array(t, arr, tags, ref)
 }
 
@@ -123,38 +134,78 @@ Size cannot determine the length of Slice types unless a layout annotation
 exists (`sizeOf` or `countOf`) that contains a non-zero value.
 */
 func Size(s interface{}) (uint64, error) {
-	return size(reflect.ValueOf(s))
+	return size(reflect.ValueOf(s), Options{})
 }
 
-func size(value reflect.Value) (uint64, error) {
+// SizeWithOptions is Size with the defaults given by opts.
+func SizeWithOptions(s interface{}, opts Options) (uint64, error) {
+	return size(reflect.ValueOf(s), opts)
+}
+
+func size(value reflect.Value, opts Options) (uint64, error) {
 
 	s := sizer{
 		size: 0,
 	}
 
-	t := newTranscoder(&s)
+	t, err := newTranscoder(&s, opts)
+	if err != nil {
+		return 0, err
+	}
+	s.transcoder = t
 
 	if err := t.transcode(value, nil); err != nil {
 		return 0, err
 	}
 
 	if s.nbits != 0 {
-		return 0, fmt.Errorf("Left-over bits in structure definition")
+		return 0, fmt.Errorf("Left-over bits in structure definition: %w", ErrTag)
 	}
 
 	return s.nbytes, nil
 }
 
+// elemBits returns the size in bits of the elements of a field of type typ
+// when it is defined by the field annotations rather than the element type,
+// i.e. for bitfields, strings and half-precision floats.
+func elemBits(typ reflect.Type, tags *tags) (uint64, bool) {
+	elem := elemType(typ)
+	if tags == nil || tags.bitfield.nbits == 0 || elem.Kind() == reflect.Struct || isCustom(elem) {
+		return 0, false
+	}
+
+	return tags.bitfield.nbits, true
+}
+
+// elemSize returns the size in bytes of the elements of the array or slice
+// arr, a field annotated with tags.
+func (t *transcoder) elemSize(arr reflect.Value, tags *tags) (uint64, error) {
+	if nbits, ok := elemBits(arr.Type(), tags); ok && nbits%8 == 0 {
+		return nbits / 8, nil
+	}
+
+	if arr.Len() == 0 {
+		return typeSize(arr.Type().Elem(), t.options)
+	}
+
+	return size(arr.Index(0), t.options)
+}
+
 // typeSize returns the size of the type t and all nested types.
 // Unlike getValueSize, getTypeSize cannot return the size of slices
 // as it is only aware of the types (and not values)
-func typeSize(t reflect.Type) (uint64, error) {
+func typeSize(t reflect.Type, opts Options) (uint64, error) {
+
+	// Custom types report the size of their zero value.
+	if isCustom(t) {
+		return size(reflect.New(t).Elem(), opts)
+	}
 
 	switch t.Kind() {
 	case reflect.Struct:
-		return structTypeSize(t)
+		return structTypeSize(t, opts)
 	case reflect.Array:
-		return typeSize(t.Elem())
+		return typeSize(t.Elem(), opts)
 	case reflect.Slice:
 		return 0, CannotDeductSliceLengthError
 	default:
@@ -162,16 +213,60 @@ func typeSize(t reflect.Type) (uint64, error) {
 	}
 }
 
-func structTypeSize(t reflect.Type) (uint64, error) {
+func structTypeSize(t reflect.Type, opts Options) (uint64, error) {
 	var bytes uint64 = 0
 	var bits uint64 = 0
 
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
+	plan, err := planOf(t)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range plan.fields {
+		f := t.Field(plan.fields[i].index)
+
+		// Fields at an offset are not part of the structure
+		if len(plan.fields[i].tags.offset.name) != 0 {
+			continue
+		}
+
+		// Non-optional pointers always transcode the value pointed to
+		if plan.fields[i].optional {
+			return 0, fmt.Errorf("Size of structure %s depends on the presence of field %s", t.Name(), f.Name)
+		}
+		for f.Type.Kind() == reflect.Ptr {
+			f.Type = f.Type.Elem()
+		}
+
+		if plan.fields[i].tags.cond != nil {
+			return 0, fmt.Errorf("Size of structure %s depends on the condition of field %s", t.Name(), f.Name)
+		}
+		if f.Type.Kind() == reflect.Interface {
+			return 0, fmt.Errorf("Size of structure %s depends on the case of union %s", t.Name(), f.Name)
+		}
+
+		if isCustom(f.Type) {
+			sz, err := typeSize(f.Type, opts)
+			if err != nil {
+				return 0, err
+			}
+			bits += sz * 8
+			continue
+		}
 
 		switch f.Type.Kind() {
+		case reflect.Struct:
+			sz, err := structTypeSize(f.Type, opts)
+			if err != nil {
+				return 0, err
+			}
+			bits += sz * 8
 		case reflect.Array:
-			sz, err := typeSize(f.Type)
+			if nbits, ok := elemBits(f.Type, &plan.fields[i].tags); ok {
+				bits += nbits * uint64(f.Type.Len())
+				break
+			}
+			sz, err := typeSize(f.Type, opts)
 			if err != nil {
 				return 0, err
 			}
@@ -179,9 +274,7 @@ func structTypeSize(t reflect.Type) (uint64, error) {
 		case reflect.Slice:
 			return 0, CannotDeductSliceLengthError
 		default:
-			tags := parseFieldTags(f)
-
-			bits += uint64(tags.bitfield.nbits)
+			bits += uint64(plan.fields[i].tags.bitfield.nbits)
 		}
 
 		for ; bits >= 8; bits -= 8 {
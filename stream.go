@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+/*
+An Encoder writes a sequence of structures to an output stream. Unlike
+Encode, the bit and byte position of the stream is kept between calls so
+records may share a byte, and alignment annotations are relative to the
+start of the stream.
+
+Output is buffered; Flush must be called once all structures are encoded.
+*/
+type Encoder struct {
+	w    *bufio.Writer
+	e    encoder
+	opts Options
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	enc := &Encoder{
+		w: bufio.NewWriter(w),
+	}
+	enc.e.writer = enc.w
+
+	return enc
+}
+
+// SetOptions sets the options used by subsequent calls to Encode.
+func (enc *Encoder) SetOptions(opts Options) {
+	enc.opts = opts
+}
+
+// Encode writes the encoding of s to the stream, continuing from where the
+// previous structure finished. If an error is returned the stream is left
+// at an undefined position.
+func (enc *Encoder) Encode(s interface{}) error {
+	return enc.e.encode(s, enc.opts)
+}
+
+// Offset returns the number of whole bytes written to the stream.
+func (enc *Encoder) Offset() uint64 {
+	return enc.e.byteOffset
+}
+
+// Flush pads any partially written byte with zeros, so the stream ends on a
+// byte boundary, and writes any buffered data to the underlying io.Writer.
+func (enc *Encoder) Flush() error {
+	if enc.e.bitOffset != 0 {
+		if err := enc.e.write(0, 8-enc.e.bitOffset); err != nil {
+			return err
+		}
+	}
+
+	return enc.w.Flush()
+}
+
+/*
+A Decoder reads a sequence of structures from an input stream. Unlike
+Decode, the bit and byte position of the stream is kept between calls so
+records may share a byte, and alignment annotations are relative to the
+start of the stream.
+
+The Decoder buffers its input and may read beyond the structures decoded.
+*/
+type Decoder struct {
+	d    decoder
+	opts Options
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	dec := &Decoder{}
+	dec.d.reader = bufio.NewReader(r)
+
+	return dec
+}
+
+// SetOptions sets the options used by subsequent calls to Decode.
+func (dec *Decoder) SetOptions(opts Options) {
+	dec.opts = opts
+}
+
+// Decode reads the next encoded structure from the stream into s. At the
+// end of the stream Decode returns io.EOF; if the stream ends part way
+// through the structure, even one starting in the bits left over of the
+// last byte, the error is ErrShortBuffer. If any other error is returned
+// the stream is left at an undefined position.
+func (dec *Decoder) Decode(s interface{}) error {
+	start, bit := dec.d.byteOffset, dec.d.bitOffset
+
+	err := dec.d.decode(s, dec.opts)
+	if errors.Is(err, io.EOF) && dec.d.byteOffset == start && dec.d.bitOffset == bit {
+		return io.EOF
+	}
+
+	return err
+}
+
+// Offset returns the number of bytes consumed from the stream, including
+// a byte of which only some bits have been decoded.
+func (dec *Decoder) Offset() uint64 {
+	return dec.d.byteOffset
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type streamRecord struct {
+	Type   uint8  `bitfield:"4"`
+	Length uint16 `bitfield:"12" big:""`
+}
+
+type streamAligned struct {
+	Tag   uint8
+	Value uint32 `align:"4"`
+}
+
+func TestStreamEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+
+	for i, s := range []interface{}{
+		streamRecord{Type: 0x1, Length: 0x234},
+		streamRecord{Type: 0x5, Length: 0x678},
+		struct {
+			A uint8 `bitfield:"4"`
+		}{0xF},
+		streamAligned{Tag: 0xAA, Value: 0x01020304},
+	} {
+		if err := enc.Encode(s); err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+	}
+
+	if enc.Offset() != 12 {
+		t.Errorf("Invalid offset: Expected: %d Actual: %d", 12, enc.Offset())
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("Output written before Flush: %x", buf.Bytes())
+	}
+
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		0x21, 0x34,
+		0x65, 0x78,
+		0xAF, 0x0A, 0x00, 0x00,
+		0x04, 0x03, 0x02, 0x01,
+	}
+
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Invalid stream:\nExpected: %x\nActual:   %x", expected, buf.Bytes())
+	}
+}
+
+func TestStreamSharedByte(t *testing.T) {
+	type nibble struct {
+		A uint8 `bitfield:"4"`
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range []uint8{1, 2, 3} {
+		if err := enc.Encode(nibble{v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []byte{0x21, 0x03}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Invalid stream: Expected: %x Actual: %x", expected, buf.Bytes())
+	}
+
+	dec := NewDecoder(&buf)
+	for _, v := range []uint8{1, 2, 3, 0} {
+		var n nibble
+		if err := dec.Decode(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n.A != v {
+			t.Errorf("Invalid nibble: Expected: %d Actual: %d", v, n.A)
+		}
+	}
+
+	if err := dec.Decode(new(nibble)); err != io.EOF {
+		t.Errorf("Expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestStreamDecoder(t *testing.T) {
+	stream := []byte{
+		0x21, 0x34,
+		0x65, 0x78,
+		0xAA, 0x00, 0x00, 0x00,
+		0x04, 0x03, 0x02, 0x01,
+	}
+
+	dec := NewDecoder(bytes.NewReader(stream))
+
+	records := []streamRecord{}
+	for i := 0; i < 2; i++ {
+		var r streamRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+
+	expected := []streamRecord{{Type: 0x1, Length: 0x234}, {Type: 0x5, Length: 0x678}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Invalid records: Expected: %+v Actual: %+v", expected, records)
+	}
+
+	var a streamAligned
+	if err := dec.Decode(&a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Tag != 0xAA || a.Value != 0x01020304 {
+		t.Errorf("Invalid aligned record: %+v", a)
+	}
+
+	if dec.Offset() != uint64(len(stream)) {
+		t.Errorf("Invalid offset: Expected: %d Actual: %d", len(stream), dec.Offset())
+	}
+
+	if err := dec.Decode(&a); err != io.EOF {
+		t.Errorf("Expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestStreamDecoderUnexpectedEOF(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte{0x21, 0x34, 0x65}))
+
+	var r streamRecord
+	if err := dec.Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&r); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for partial record, got %v", err)
+	}
+}
+
+func TestStreamDecoderSharedByteEOF(t *testing.T) {
+	type record struct {
+		A uint8 `bitfield:"4"`
+		B uint8
+	}
+
+	dec := NewDecoder(bytes.NewReader([]byte{0x21, 0x43}))
+
+	var r record
+	if err := dec.Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second record starts in the left over bits of the last byte
+	if err := dec.Decode(&r); err == io.EOF || !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("Expected ErrShortBuffer for partial record, got %v", err)
+	}
+}
+
+func TestStreamOptions(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetOptions(Options{ByteOrder: BigEndian})
+
+	if err := enc.Encode(struct{ A uint16 }{0x1234}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(&buf)
+	dec.SetOptions(Options{ByteOrder: BigEndian})
+
+	var s struct{ A uint16 }
+	if err := dec.Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.A != 0x1234 {
+		t.Errorf("Invalid value: Expected: %#x Actual: %#x", 0x1234, s.A)
+	}
+}
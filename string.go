@@ -0,0 +1,103 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stringFormat describes the fixed-width wire format of a string field.
+type stringFormat struct {
+	width uint64 // Width of the field in bytes
+	pad   byte   // Byte filling the field beyond the string
+	nul   bool   // String is terminated by a NUL within the field
+}
+
+func parseStringFormat(val string) (stringFormat, bool) {
+	opts := strings.Split(val, ",")
+
+	width, err := strconv.ParseUint(opts[0], 0, 32)
+	if err != nil || width == 0 {
+		return stringFormat{}, false
+	}
+
+	f := stringFormat{width: width}
+	for _, opt := range opts[1:] {
+		switch strings.ToLower(strings.TrimSpace(opt)) {
+		case "nul":
+			f.nul = true
+		case "pad=space":
+			f.pad = ' '
+		case "pad=nul", "pad=zero":
+			f.pad = 0
+		default:
+			return stringFormat{}, false
+		}
+	}
+
+	return f, true
+}
+
+// stringBytes returns the string s padded to the width of the field.
+func stringBytes(s string, tags *tags) ([]byte, error) {
+	f := tags.str
+
+	max := f.width
+	if f.nul {
+		max-- // Room for the terminator
+	}
+
+	if uint64(len(s)) > max {
+		return nil, fmt.Errorf("String of length %d overflows field of %d bytes: %w", len(s), f.width, ErrOverflow)
+	}
+
+	b := make([]byte, f.width)
+	copy(b, s)
+
+	i := len(s)
+	if f.nul {
+		i++ // Terminator is always NUL
+	}
+	for ; i < len(b); i++ {
+		b[i] = f.pad
+	}
+
+	return b, nil
+}
+
+// stringFromBytes returns the string held in the fixed-width field b
+// without its terminator or padding.
+func stringFromBytes(b []byte, tags *tags) string {
+	f := tags.str
+
+	if f.nul {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+	}
+
+	return string(bytes.TrimRight(b, string([]byte{f.pad})))
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type stringStruct struct {
+	Vendor   string    `string:"8,pad=space"`
+	Serial   string    `string:"6,nul"`
+	Name     string    `structex:"string='4'"`
+	Count    uint8     `countOf:"Labels"`
+	Labels   []string  `string:"3,pad=space"`
+	Length   uint8     `sizeOf:"Revision"`
+	Revision []string  `string:"2"`
+	Fixed    [2]string `string:"2,nul,pad=space"`
+}
+
+var stringBytesEncoded = []byte{
+	'H', 'P', 'E', ' ', ' ', ' ', ' ', ' ',
+	'S', 'N', '1', 0, 0, 0,
+	'a', 'b', 0, 0,
+	2,
+	'A', ' ', ' ', 'B', 'C', 'D',
+	4,
+	'1', 0, '2', '3',
+	'x', 0, 0, ' ',
+}
+
+func TestStringEncoder(t *testing.T) {
+	s := stringStruct{
+		Vendor:   "HPE",
+		Serial:   "SN1",
+		Name:     "ab",
+		Labels:   []string{"A", "BCD"},
+		Revision: []string{"1", "23"},
+		Fixed:    [2]string{"x", ""},
+	}
+
+	packAndTest(t, s, func(t *testing.T, tw *testWriter) {
+		if actual := tw.getBytes(0, tw.getSize()-1); !bytes.Equal(actual, stringBytesEncoded) {
+			t.Errorf("Invalid string encoding:\nExpected: %q\nActual:   %q", stringBytesEncoded, actual)
+		}
+	})
+
+	sz, err := Size(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sz != uint64(len(stringBytesEncoded)) {
+		t.Errorf("Invalid string structure size: Expected: %d Actual: %d", len(stringBytesEncoded), sz)
+	}
+}
+
+func TestStringDecoder(t *testing.T) {
+	expected := stringStruct{
+		Vendor:   "HPE",
+		Serial:   "SN1",
+		Name:     "ab",
+		Count:    2,
+		Labels:   []string{"A", "BCD"},
+		Length:   4,
+		Revision: []string{"1", "23"},
+		Fixed:    [2]string{"x", ""},
+	}
+
+	unpackAndTest(t, new(stringStruct), newReader(stringBytesEncoded), func(t *testing.T, i interface{}) {
+		if s := i.(*stringStruct); !reflect.DeepEqual(*s, expected) {
+			t.Errorf("Invalid string decoding:\nExpected: %q\nActual:   %q", expected, *s)
+		}
+	})
+}
+
+func TestStringOverflow(t *testing.T) {
+	tests := []interface{}{
+		struct {
+			S string `string:"4"`
+		}{"abcde"},
+		struct {
+			S string `string:"4,nul"`
+		}{"abcd"},
+		struct {
+			S [1]string `string:"2,pad=space"`
+		}{[1]string{"abc"}},
+	}
+
+	for _, s := range tests {
+		if err := Encode(&testWriter{}, s); err == nil {
+			t.Errorf("Expected overflow error for %+v", s)
+		}
+	}
+}
+
+func TestStringTaggingError(t *testing.T) {
+	type untagged struct {
+		S string
+	}
+	type bitfieldString struct {
+		S string `bitfield:"8"`
+	}
+	type unknownOption struct {
+		S string `string:"8,pad=tab"`
+	}
+	type zeroWidth struct {
+		S string `string:"0"`
+	}
+	type intString struct {
+		I uint32 `string:"4"`
+	}
+
+	for _, s := range []interface{}{untagged{}, bitfieldString{}, unknownOption{}, zeroWidth{}, intString{}} {
+		if _, err := Size(s); err == nil {
+			t.Errorf("Expected tagging error for %T", s)
+		}
+	}
+}
@@ -27,6 +27,8 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+
+	"github.com/HewlettPackard/structex/internal/tag"
 )
 
 type endian int
@@ -37,6 +39,14 @@ const (
 	undefined endian = 2
 )
 
+type bitOrder int
+
+const (
+	lsbFirst       bitOrder = 0
+	msbFirst       bitOrder = 1
+	orderUndefined bitOrder = 2
+)
+
 type bitfield struct {
 	nbits    uint64
 	reserved bool
@@ -52,17 +62,36 @@ type layout struct {
 	format   int
 	name     string
 	relative bool
-	value    uint64
+	scale    uint64 // Units of the value, i.e. 4 for a count of dwords
+	bias     int64  // Added to the scaled value, i.e. 1 for 0's based values
+	origin   origin // Start of the bytes counted by a sizeOf
+}
+
+// location is the field holding the offset of a field decoded out of line.
+type location struct {
+	name     string
+	relative bool
 }
 
 type alignment uint64
 
 type tags struct {
 	endian    endian
+	bitOrder  bitOrder
 	bitfield  bitfield
 	layout    layout
 	alignment alignment
 	truncate  bool
+	greedy    bool      // Slice runs to the end of the input
+	sentinel  *sentinel // Element ending the slice, if any
+	float     floatFormat
+	str       stringFormat
+	constant  constant
+	checksum  checksum
+	cond      *condition
+	union     string // Field selecting the case of a union
+	offset    location
+	tag       reflect.StructTag
 }
 
 // A TaggingError occurs when the pack/unpack routines have
@@ -77,22 +106,43 @@ func (e *TaggingError) Error() string {
 	return fmt.Sprintf("Invalid tag '%s' for %s", e.tag, e.kind.String())
 }
 
+// Is reports a TaggingError as matching ErrTag.
+func (e *TaggingError) Is(target error) bool {
+	return target == ErrTag
+}
+
 /*
 Method which parses the field tags and returns a series of informative
-structures defined by the the structure extension values.
+structures defined by the the structure extension values. A TaggingError
+is returned if an annotation cannot be applied to the field.
 */
-func parseFieldTags(sf reflect.StructField) tags {
+func parseFieldTags(sf reflect.StructField) (tags, error) {
 	t := tags{
 		endian:    undefined,
+		bitOrder:  orderUndefined,
 		bitfield:  bitfield{0, false},
-		layout:    layout{none, "", false, 0},
+		layout:    layout{format: none, scale: 1},
 		alignment: 0,
 		truncate:  false,
+		tag:       sf.Tag,
+	}
+
+	// Pointers are annotated as the value they point to
+	ptr := sf.Type.Kind() == reflect.Ptr
+	for sf.Type.Kind() == reflect.Ptr {
+		sf.Type = sf.Type.Elem()
+	}
+
+	// Maps, channels, functions and the like have no wire format
+	switch elemKind(sf.Type) {
+	case reflect.Map, reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128,
+		reflect.Uintptr, reflect.UnsafePointer:
+		return t, &TaggingError{string(sf.Tag), sf.Type.Kind()}
 	}
 
 	// Always encode the size of the field, regardless of tags
 	switch sf.Type.Kind() {
-	case reflect.Array, reflect.Slice, reflect.Struct, reflect.Ptr:
+	case reflect.Array, reflect.Slice, reflect.Struct, reflect.String, reflect.Interface:
 		break
 	case reflect.Bool:
 		t.bitfield.nbits = 1
@@ -100,85 +150,39 @@ func parseFieldTags(sf reflect.StructField) tags {
 		t.bitfield.nbits = uint64(sf.Type.Bits())
 	}
 
-	if s, ok := sf.Tag.Lookup("structex"); ok {
-		t.parseString(sf, s, parseOptions{sep: ',', quote: '\'', assign: '='})
-	} else {
-		t.parseString(sf, string(sf.Tag), parseOptions{sep: ' ', quote: '"', assign: ':'})
+	for _, attr := range tag.Parse(sf.Tag) {
+		if err := t.add(sf, attr.Key, attr.Value); err != nil {
+			return t, err
+		}
 	}
 
-	return t
-}
-
-type parseOptions struct {
-	sep    rune
-	quote  rune
-	assign rune
-}
-
-// Full tag format i.e. `structex:"bitfield='4,reserved',sizeof='Array'"`
-// Bare tag format i.e. `bitfield:"3,reserved" sizeOf:"Array"`
-func (t *tags) parseString(sf reflect.StructField, tagString string, opts parseOptions) {
-	if len(tagString) == 0 {
-		return
+	// Strings have no natural width on the wire, unless that of a constant
+	if sf.Type.Kind() == reflect.String && t.str.width == 0 && t.constant.bytes != nil {
+		t.str.width = uint64(len(t.constant.bytes))
+		t.bitfield.nbits = t.str.width * 8
 	}
-
-	key := []rune{}
-	val := []rune{}
-
-	inKey := true
-	inVal := false
-
-	addKey := func(r rune) {
-		key = append(key, r)
+	if elemKind(sf.Type) == reflect.String && t.str.width == 0 {
+		return t, &TaggingError{string(sf.Tag), sf.Type.Kind()}
 	}
 
-	addVal := func(r rune) {
-		val = append(val, r)
+	if !t.constant.fits(t.bitfield.nbits, isSigned(sf.Type.Kind())) {
+		return t, &TaggingError{string(sf.Tag), sf.Type.Kind()}
 	}
-
-	runes := []rune(tagString)
-	for idx, r := range runes {
-
-		switch r {
-		case opts.assign:
-			if inKey {
-				inKey = false
-			}
-		case opts.quote:
-			if inVal {
-				inVal = false
-				goto ADDTAG
-			} else {
-				inVal = true
-			}
-		case opts.sep, ',':
-			if !inVal {
-				inKey = true
-			} else {
-				addVal(r)
-			}
-		default:
-			if inKey {
-				addKey(r)
-				if idx == len(runes)-1 {
-					goto ADDTAG
-				}
-			} else if inVal {
-				addVal(r)
-			}
+	if t.constant.bytes != nil && sf.Type.Kind() == reflect.String {
+		if _, err := stringBytes(string(t.constant.bytes), &t); err != nil {
+			return t, &TaggingError{string(sf.Tag), sf.Type.Kind()}
 		}
+	}
 
-		continue
-
-	ADDTAG:
-		t.add(sf, string(key), string(val))
-		key = []rune{}
-		val = []rune{}
-
+	// Checksums are set in place, so must be held by the structure itself
+	if ptr && t.checksum.fn != nil {
+		return t, &TaggingError{string(sf.Tag), reflect.Ptr}
 	}
+
+	return t, nil
 }
 
-func (t *tags) add(sf reflect.StructField, key string, val string) {
+func (t *tags) add(sf reflect.StructField, key string, val string) error {
 	switch strings.ToLower(key) {
 	case "little":
 		t.endian = little
@@ -186,17 +190,31 @@ func (t *tags) add(sf reflect.StructField, key string, val string) {
 	case "big":
 		t.endian = big
 
+	case "bitorder":
+		switch strings.ToLower(val) {
+		case "lsb":
+			t.bitOrder = lsbFirst
+		case "msb":
+			t.bitOrder = msbFirst
+		default:
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+
 	case "bitfield":
 		if nbs := strings.Split(val, ",")[0]; len(nbs) != 0 {
 			var nbits int64
-			switch sf.Type.Kind() {
+			switch elemKind(sf.Type) {
 			case reflect.Bool:
 				nbits = 1
+			case reflect.Struct, reflect.Slice, reflect.Array, reflect.Ptr, reflect.Float32, reflect.Float64, reflect.String, reflect.Interface:
+				return &TaggingError{string(sf.Tag), sf.Type.Kind()}
 			default:
+				// Bitfields must hold at least one bit, and no more than
+				// the type of the field
 				var err error
-				nbits, err = strconv.ParseInt(nbs, 0, int(sf.Type.Bits()))
-				if err != nil {
-					panic(&TaggingError{string(sf.Tag), sf.Type.Kind()})
+				nbits, err = strconv.ParseInt(nbs, 0, 64)
+				if err != nil || nbits <= 0 || nbits > int64(elemType(sf.Type).Bits()) {
+					return &TaggingError{string(sf.Tag), sf.Type.Kind()}
 				}
 			}
 			t.bitfield.nbits = uint64(nbits)
@@ -206,27 +224,129 @@ func (t *tags) add(sf reflect.StructField, key string, val string) {
 
 	case "sizeof":
 		t.layout.format = sizeOf
-		t.layout.name = strings.Split(val, ",")[0]
-		t.layout.relative = strings.Contains(val, "relative")
+		if !t.layout.parse(val) {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
 
 	case "countof":
 		t.layout.format = countOf
-		t.layout.name = val
+		if !t.layout.parse(val) || t.layout.relative || t.layout.origin != originNone {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
 
 	case "truncate":
 		t.truncate = true
 
+	case "greedy", "rest":
+		if sf.Type.Kind() != reflect.Slice {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.greedy = true
+
+	case "until", "terminator":
+		if sf.Type.Kind() != reflect.Slice || sf.Type.Elem().Kind() == reflect.Ptr {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+
+		st, ok := parseSentinel(strings.ToLower(key), val, sf.Type.Elem())
+		if !ok {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.sentinel = st
+
+	case "float":
+		if k := elemKind(sf.Type); k != reflect.Float32 && k != reflect.Float64 {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+
+		switch strings.ToLower(val) {
+		case "half", "float16":
+			t.float = floatHalf
+		case "bfloat16":
+			t.float = floatBrain
+		default:
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.bitfield.nbits = 16
+
+	case "string":
+		if elemKind(sf.Type) != reflect.String {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+
+		f, ok := parseStringFormat(val)
+		if !ok {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.str = f
+		t.bitfield.nbits = f.width * 8
+
+	case "const":
+		c, ok := parseConstant(val, sf.Type)
+		if !ok {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.constant = c
+
+	case "checksum":
+		switch sf.Type.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		if len(sf.PkgPath) != 0 {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+
+		c, ok := parseChecksum(val)
+		if !ok {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.checksum = c
+
+	case "if":
+		c, ok := parseCondition(val)
+		if !ok {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.cond = c
+
+	case "switch":
+		if sf.Type.Kind() != reflect.Interface || len(val) == 0 {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+		t.union = val
+
+	case "offset":
+		t.offset.name = strings.Split(val, ",")[0]
+		t.offset.relative = strings.Contains(val, "relative")
+		if len(t.offset.name) == 0 {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
+		}
+
 	case "align":
 		align, err := strconv.ParseInt(val, 0, 64)
-		if err != nil {
-			panic(&TaggingError{string(sf.Tag), sf.Type.Kind()})
+		if err != nil || align < 0 {
+			return &TaggingError{string(sf.Tag), sf.Type.Kind()}
 		}
 		t.alignment = alignment(align)
 	}
+
+	return nil
 }
 
-func (t *tags) parseBitfield(sf reflect.StructField, s string, opts parseOptions) {
+// elemType returns the type of the elements of an array or slice, or the
+// type itself for any other kind.
+func elemType(typ reflect.Type) reflect.Type {
+	switch typ.Kind() {
+	case reflect.Array, reflect.Slice:
+		return typ.Elem()
+	}
+	return typ
+}
 
+func elemKind(typ reflect.Type) reflect.Kind {
+	return elemType(typ).Kind()
 }
 
 func (t *tags) print() {
@@ -30,7 +30,10 @@ import (
 func testTags(t *testing.T, s interface{}, i int, test func(t tags) bool) {
 	val := reflect.ValueOf(s)
 	typ := val.Type()
-	tags := parseFieldTags(typ.Field(i))
+	tags, err := parseFieldTags(typ.Field(i))
+	if err != nil {
+		t.Fatalf("Parsing field %d '%s' failed: %v", i, typ.Field(i).Name, err)
+	}
 	if !test(tags) {
 		t.Errorf("Test on field %d '%s' failed: tags %+v", i, typ.Field(i).Name, tags)
 	}
@@ -23,8 +23,9 @@ USE OR OTHER DEALINGS IN THE SOFTWARE.
 package structex
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"math"
 	"reflect"
 	"strings"
 )
@@ -32,57 +33,87 @@ import (
 const (
 	// EnvVarDefaultEndianness is the name of the environment variable used to
 	// define the default endian format for all structure elements unless otherwise
-	// sepcified. Default to little-endian
+	// sepcified. Default to little-endian. Only consulted when the Options of
+	// a call leave the ByteOrder as DefaultByteOrder.
 	EnvVarDefaultEndianness = "X_STRUCTEX_DEFAULT_ENDIANNESS"
 )
 
 type tagReference struct {
-	value reflect.Value // Value of field tagged with `sizeOf` or `countOf`.
-	tags  *tags         // The tag attributes of the field tagged with `sizeOf` or `countOf`.
+	value       reflect.Value // Value of field tagged with `sizeOf` or `countOf`.
+	tags        *tags         // The tag attributes of the field tagged with `sizeOf` or `countOf`.
+	target      *tags         // The tag attributes of the referenced array or slice.
+	layoutValue uint64        // The size or count once the field has been transcoded.
+	index       int           // Index of the field tagged with `sizeOf` or `countOf`.
+	origin      uint64        // Byte offset a sizeOf counts from, if any.
+	end         uint64        // Byte offset of the end of the field tagged with `sizeOf` or `countOf`.
+}
+
+type frame struct {
+	val    reflect.Value
+	plan   *structPlan
+	order  bitOrder
+	offset uint64        // Byte offset of the start of the structure
+	rest   *tagReference // Layout of the remainder of the structure, if any
 }
 
 type stack struct {
 	len  int
-	vals []reflect.Value
+	vals []frame
+}
+
+// A pathElem is a field name, or the index of an array or slice element if
+// name is empty.
+type pathElem struct {
+	name  string
+	index int
 }
 
 type handler interface {
+	position() (offset uint64, bit uint64)
 	align(a alignment) error
+	handles(typ reflect.Type) bool
+	custom(val reflect.Value, tags *tags) error
 	field(val reflect.Value, tags *tags) error
 	layout(val reflect.Value, ref *tagReference) error
 	array(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error
 	slice(t *transcoder, arr reflect.Value, tags *tags, ref *tagReference) error
+	checksummed(t *transcoder, val reflect.Value, plan *structPlan, order bitOrder) error
+	discriminator(val reflect.Value, tags *tags, union reflect.Value) error
+	union(t *transcoder, val reflect.Value, tags *tags) error
+	pointer(t *transcoder, val reflect.Value, elem *fieldPlan) error
+	offset(t *transcoder, val reflect.Value, field *fieldPlan) error
+	bounded(ref *tagReference, start uint64, fn func() error) error
 }
 
 type transcoder struct {
 	handler           handler
 	fieldMap          map[string]*tagReference
 	backtrace         stack
+	path              []pathElem
+	options           Options
 	defaultEndianness endian
+	defaultBitOrder   bitOrder
+	reflected         map[reflect.Type]bool // Types reflected even if transcoding themselves, see CheckGenerated
 }
 
-func newTranscoder(h handler) *transcoder {
+func newTranscoder(h handler, opts Options) (*transcoder, error) {
 
 	t := transcoder{
-		handler:           h,
-		fieldMap:          make(map[string]*tagReference),
-		backtrace:         stack{len: 0},
-		defaultEndianness: little,
+		handler:   h,
+		fieldMap:  make(map[string]*tagReference),
+		backtrace: stack{len: 0},
+		options:   opts,
 	}
 
-	endianness, present := os.LookupEnv(EnvVarDefaultEndianness)
-	if present {
-		switch strings.ToLower(endianness) {
-		case "little":
-			t.defaultEndianness = little
-		case "big":
-			t.defaultEndianness = big
-		default:
-			panic(fmt.Sprintf("Detected EnvVar %s: Endian '%s' not recognized. Should be one of 'big' or 'little'", EnvVarDefaultEndianness, endianness))
-		}
+	var err error
+	if t.defaultEndianness, err = opts.endianness(); err != nil {
+		return nil, err
+	}
+	if t.defaultBitOrder, err = opts.bitOrder(); err != nil {
+		return nil, err
 	}
 
-	return &t
+	return &t, nil
 }
 
 func (t *transcoder) transcode(val reflect.Value, rtags *tags) error {
@@ -92,6 +123,33 @@ func (t *transcoder) transcode(val reflect.Value, rtags *tags) error {
 		val = val.Elem()
 	}
 
+	// Types implementing Marshaler, Unmarshaler or Sizer transcode
+	// themselves, for the operations they implement. Errors of values
+	// held by a field are annotated with that field, those of the top
+	// level value with its type.
+	if t.handles(val.Type()) {
+		offset, bit := t.handler.position()
+		err := t.handler.custom(val, rtags)
+
+		var fe *FieldError
+		if err != nil && t.backtrace.len == 0 && !errors.As(err, &fe) {
+			return fieldError(err, val.Type().Name(), offset, bit)
+		}
+		return err
+	}
+
+	return t.transcodeReflect(val, rtags)
+}
+
+// transcodeReflect transcodes val by reflecting over its fields, even if
+// val itself implements one of the custom interfaces. Nested values are
+// still given the opportunity to transcode themselves.
+func (t *transcoder) transcodeReflect(val reflect.Value, rtags *tags) error {
+
+	for val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+
 	// Top level calls should always be of struct type, but
 	// we all recursive calls of transcode so must handle
 	// raw types.
@@ -99,81 +157,263 @@ func (t *transcoder) transcode(val reflect.Value, rtags *tags) error {
 		return t.handler.field(val, rtags)
 	}
 
-	t.backtrace.push(val)
+	plan, err := planOf(val.Type())
+	if err != nil {
+		return err
+	}
+
+	// The bit order of the structure is, in order of precedence, that
+	// declared by the structure itself, by the field holding it, or that
+	// of the enclosing structure.
+	order := plan.order
+	if order == orderUndefined && rtags != nil {
+		order = rtags.bitOrder
+	}
+	if order == orderUndefined {
+		order = t.bitOrder(nil)
+	}
+
+	if len(plan.checksums) != 0 {
+		return t.handler.checksummed(t, val, plan, order)
+	}
+
+	return t.transcodeStruct(val, plan, order, nil)
+}
+
+// transcodeStruct transcodes the fields of the structure val. If spans is
+// not nil the bytes each field was transcoded to are recorded.
+func (t *transcoder) transcodeStruct(val reflect.Value, plan *structPlan, order bitOrder, spans []span) error {
+	start, _ := t.handler.position()
+	t.backtrace.push(val, plan, order, start)
 	defer t.backtrace.pop()
 
-	typ := val.Type()
+	for i := range plan.fields {
+		if err := t.transcodeIndex(val, plan, i, spans); err != nil {
+			return err
+		}
 
-	for i := 0; i < val.NumField(); i++ {
-		fieldVal := val.Field(i)
-		fieldTyp := typ.Field(i)
+		// Fields following a `sizeOf:"$rest"` are bounded by its size
+		if ref := t.backtrace.vals[t.backtrace.len-1].rest; ref != nil {
+			return t.transcodeRest(val, plan, i, spans, ref)
+		}
+	}
+
+	return nil
+}
 
-		tags := parseFieldTags(fieldTyp)
+// transcodeRest transcodes the fields of the structure val following field
+// i, which gives their size as `sizeOf:"$rest"` in ref.
+func (t *transcoder) transcodeRest(val reflect.Value, plan *structPlan, i int, spans []span, ref *tagReference) error {
+	var inner error
 
-		if tags.alignment != 0 {
-			if err := t.handler.align(tags.alignment); err != nil {
-				return err
+	err := t.handler.bounded(ref, ref.end, func() error {
+		for j := i + 1; j < len(plan.fields); j++ {
+			if inner = t.transcodeIndex(val, plan, j, spans); inner != nil {
+				return inner
 			}
 		}
+		return nil
+	})
+
+	if inner != nil {
+		return inner
+	}
+	if err != nil {
+		return fieldError(err, plan.fields[i].name, ref.end, 0)
+	}
+
+	return nil
+}
+
+// transcodeIndex transcodes field i of the structure val, recording its
+// span if spans is not nil.
+func (t *transcoder) transcodeIndex(val reflect.Value, plan *structPlan, i int, spans []span) error {
+	field := &plan.fields[i]
+
+	offset, bit := t.handler.position()
+
+	present := true
+	if c := field.tags.cond; c != nil {
+		var err error
+		if present, err = c.evaluate(t); err != nil {
+			return fieldError(err, field.name, offset, bit)
+		}
+	}
 
-		switch fieldTyp.Type.Kind() {
+	if present {
+		t.enter(field.name, 0)
 
-		case reflect.Struct:
-			// Nested structure, do recursive transcoding
-			if err := t.transcode(fieldVal, nil); err != nil {
-				return err
-			}
+		transcode := func() error {
+			return t.transcodeField(val.Field(field.index), field)
+		}
 
-		case reflect.Array:
-			if err := t.handler.array(t, fieldVal, &tags, t.fieldMap[fieldTyp.Name]); err != nil {
-				return err
-			}
+		// Structures sized by a sizeOf occupy exactly that many bytes
+		var err error
+		if ref := t.fieldMap[field.name]; ref != nil && ref.target == &field.tags && !isArray(field.kind) {
+			err = t.handler.bounded(ref, offset, transcode)
+		} else {
+			err = transcode()
+		}
+		if err != nil {
+			return fieldError(err, field.name, offset, bit)
+		}
 
-		case reflect.Slice:
-			if err := t.handler.slice(t, fieldVal, &tags, t.fieldMap[fieldTyp.Name]); err != nil {
-				return err
-			}
+		t.leave()
+	}
 
-		default:
+	if spans != nil {
+		spans[i] = span{start: offset, end: unaligned}
+		if bit != 0 {
+			spans[i].start = unaligned
+		}
+		if end, bit := t.handler.position(); bit == 0 {
+			spans[i].end = end
+		}
+	}
 
-			if tags.layout.format != none {
+	return nil
+}
 
-				found := t.fieldByName(tags.layout.name)
+// fork returns a transcoder for h in the state of t, for transcoding the
+// structure currently being transcoded a second time.
+func (t *transcoder) fork(h handler) *transcoder {
+	f := *t
+	f.handler = h
+	f.path = nil
 
-				if !found.IsValid() || found.Type() == reflect.PtrTo(reflect.TypeOf(reflect.Invalid)) {
-					return fmt.Errorf("cannot locate referenced field '%s'", tags.layout.name)
-				}
+	f.fieldMap = make(map[string]*tagReference, len(t.fieldMap))
+	for k, v := range t.fieldMap {
+		f.fieldMap[k] = v
+	}
 
-				if found.Kind() != reflect.Slice && found.Kind() != reflect.Array {
-					return fmt.Errorf("referenced layout must be of type slice or array; is of type %s", found.Kind().String())
-				}
+	f.backtrace = stack{
+		vals: append([]frame(nil), t.backtrace.vals[:t.backtrace.len]...),
+		len:  t.backtrace.len,
+	}
+
+	return &f
+}
+
+// transcodeField transcodes a single field of the structure currently being
+// transcoded.
+func (t *transcoder) transcodeField(fieldVal reflect.Value, field *fieldPlan) error {
+	tags := &field.tags
+
+	// Fields at an offset are not part of the structure itself
+	if len(tags.offset.name) != 0 {
+		return t.handler.offset(t, fieldVal, field)
+	}
+
+	// Pointers are transcoded as the value pointed to, any alignment
+	// included, so nothing at all is written for a nil optional field.
+	custom := field.custom && t.handles(fieldVal.Type())
 
-				ref := &tagReference{
-					value: fieldVal,
-					tags:  &tags,
+	if field.kind == reflect.Ptr && !custom {
+		elem := *field
+		elem.kind = fieldVal.Type().Elem().Kind()
+		elem.custom = isCustom(fieldVal.Type().Elem())
+
+		return t.handler.pointer(t, fieldVal, &elem)
+	}
+
+	if tags.alignment != 0 {
+		if err := t.handler.align(tags.alignment); err != nil {
+			return err
+		}
+	}
+
+	if custom {
+		return t.handler.custom(fieldVal, tags)
+	}
+
+	switch field.kind {
+
+	case reflect.Struct:
+		// Nested structure, do recursive transcoding
+		if err := t.transcode(fieldVal, tags); err != nil {
+			return err
+		}
+
+	case reflect.Array:
+		if err := t.handler.array(t, fieldVal, tags, t.fieldMap[field.name]); err != nil {
+			return err
+		}
+
+	case reflect.Slice:
+		if err := t.handler.slice(t, fieldVal, tags, t.fieldMap[field.name]); err != nil {
+			return err
+		}
+
+	case reflect.Interface:
+		if len(tags.union) == 0 {
+			return fmt.Errorf("Interface field requires a switch annotation: %w", ErrTag)
+		}
+		if err := t.handler.union(t, fieldVal, tags); err != nil {
+			return err
+		}
+
+	default:
+
+		if tags.layout.format != none {
+
+			found, target := t.fieldByName(tags.layout.name)
+
+			// The remainder of the structure is not a field to be found
+			if tags.layout.name != restOf {
+				if !found.IsValid() {
+					return fmt.Errorf("cannot locate referenced field '%s': %w", tags.layout.name, ErrTag)
 				}
 
-				if err := t.handler.layout(found, ref); err != nil {
-					return err
+				if !isLayoutTarget(found.Kind(), tags.layout.format) {
+					return fmt.Errorf("referenced layout must be of type slice or array; is of type %s: %w", found.Kind().String(), ErrTag)
 				}
+			}
 
-				t.fieldMap[tags.layout.name] = ref
+			ref := &tagReference{
+				value:  fieldVal,
+				tags:   tags,
+				target: target,
+				index:  field.index,
+			}
 
+			// Any reference left by an earlier structure would be used
+			// when sizing the field for this one
+			delete(t.fieldMap, tags.layout.name)
+
+			if err := t.handler.layout(found, ref); err != nil {
+				return err
+			}
+
+			ref.end, _ = t.handler.position()
+			ref.origin = t.origin(&tags.layout, ref.end)
+
+			if tags.layout.name == restOf {
+				t.backtrace.vals[t.backtrace.len-1].rest = ref
 			} else {
+				t.fieldMap[tags.layout.name] = ref
+			}
 
-				if err := t.handler.field(fieldVal, &tags); err != nil {
-					return err
-				}
+		} else if field.union != nil {
+
+			union := t.backtrace.vals[t.backtrace.len-1].val.Field(field.union.index)
+			if err := t.handler.discriminator(fieldVal, tags, union); err != nil {
+				return err
 			}
 
+		} else {
+
+			if err := t.handler.field(fieldVal, tags); err != nil {
+				return err
+			}
 		}
+
 	}
 
 	return nil
 }
 
-func (s *stack) push(v reflect.Value) {
-	s.vals = append(s.vals, v)
+func (s *stack) push(v reflect.Value, p *structPlan, o bitOrder, offset uint64) {
+	s.vals = append(s.vals[:s.len], frame{val: v, plan: p, order: o, offset: offset})
 	s.len = len(s.vals)
 }
 
@@ -181,20 +421,80 @@ func (s *stack) pop() {
 	s.len--
 }
 
-func (t *transcoder) fieldByName(name string) reflect.Value {
+// enter records the transcoding of the named field, or of element index if
+// name is empty. Errors unwind without calling leave, so the path is only
+// consistent until the first error is returned.
+func (t *transcoder) enter(name string, index int) {
+	t.path = append(t.path, pathElem{name: name, index: index})
+}
+
+func (t *transcoder) leave() {
+	t.path = t.path[:len(t.path)-1]
+}
+
+// fieldPath returns the path of the field currently being transcoded,
+// i.e. Descriptors[3].Length
+func (t *transcoder) fieldPath() string {
+	var b strings.Builder
+
+	for i, e := range t.path {
+		if len(e.name) == 0 {
+			fmt.Fprintf(&b, "[%d]", e.index)
+			continue
+		}
+		if i != 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(e.name)
+	}
+
+	return b.String()
+}
+
+// handles reports if values of typ transcode themselves.
+func (t *transcoder) handles(typ reflect.Type) bool {
+	return t.handler.handles(typ) && !t.reflected[typ]
+}
+
+// bitOrder returns the bit order of a field annotated with tags within the
+// structure currently being transcoded.
+func (t *transcoder) bitOrder(tags *tags) bitOrder {
+	if tags != nil && tags.bitOrder != orderUndefined {
+		return tags.bitOrder
+	}
+
+	if t.backtrace.len != 0 {
+		return t.backtrace.vals[t.backtrace.len-1].order
+	}
+
+	return t.defaultBitOrder
+}
+
+// bigEndian reports if a field annotated with tags is in big-endian format.
+func (t *transcoder) bigEndian(tags *tags) bool {
+	if tags != nil && tags.endian != undefined {
+		return tags.endian == big
+	}
+
+	return t.defaultEndianness == big
+}
+
+// fieldByName searches the structures currently being transcoded, innermost
+// first, for the named field and its tags. An invalid Value is returned if
+// none is found.
+func (t *transcoder) fieldByName(name string) (reflect.Value, *tags) {
 	for i := t.backtrace.len; i != 0; i-- {
-		found := t.backtrace.vals[i-1].FieldByName(name)
-		if found.IsValid() {
-			return found
+		f := &t.backtrace.vals[i-1]
+		if idx, ok := f.plan.fieldByName(name); ok {
+			field := &f.plan.fields[idx]
+			return f.val.Field(field.index), &field.tags
 		}
 	}
 
-	// I don't know why this doesn't return a value.Invalid()
-	// Maybe something to look into further.
-	return reflect.New(reflect.TypeOf(reflect.Invalid))
+	return reflect.Value{}, nil
 }
 
-func getValue(val reflect.Value) uint64 {
+func getValue(val reflect.Value) (uint64, error) {
 	var value uint64 = 0
 
 	switch val.Type().Kind() {
@@ -204,9 +504,32 @@ func getValue(val reflect.Value) uint64 {
 		value = uint64(val.Int())
 	case reflect.Bool:
 		value = map[bool]uint64{true: 1}[val.Bool()]
+	case reflect.Float32:
+		value = uint64(math.Float32bits(float32(val.Float())))
+	case reflect.Float64:
+		value = math.Float64bits(val.Float())
 	default:
-		panic(fmt.Errorf("Field type %s unsupported", val.Type().Kind().String()))
+		return 0, fmt.Errorf("Field type %s unsupported: %w", val.Type().Kind().String(), ErrTag)
+	}
+
+	return value, nil
+}
+
+// isInteger reports if values of kind are integers.
+func isInteger(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
 	}
+	return false
+}
 
-	return value
+// isSigned reports if values of kind are signed integers.
+func isSigned(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// A unionCase is a concrete type registered for a discriminator value.
+type unionCase struct {
+	value uint64
+	typ   reflect.Type // Registered type, a structure or pointer to one
+}
+
+var (
+	unions     = map[reflect.Type][]unionCase{} // Cases by interface, ordered by value
+	unionsLock sync.RWMutex
+)
+
+/*
+RegisterCase registers typ as the concrete type of interface fields of type
+iface annotated with `switch:"Field"` when Field holds value. Decoding
+allocates a new typ, which must be a structure or a pointer to one, while
+encoding writes value to Field for fields holding a typ. A type registered
+for several values encodes with the value already held by Field, if one of
+them, or the lowest otherwise. Registering a value again replaces the type.
+
+RegisterCase panics if iface is not an interface type, or typ does not
+implement it.
+
+	type Page interface{}
+
+	structex.RegisterCase(reflect.TypeOf((*Page)(nil)).Elem(), 0x83, reflect.TypeOf(DeviceID{}))
+*/
+func RegisterCase(iface reflect.Type, value uint64, typ reflect.Type) {
+	if iface == nil || iface.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("structex: RegisterCase of non-interface type %v", iface))
+	}
+	if typ == nil || !typ.Implements(iface) {
+		panic(fmt.Sprintf("structex: RegisterCase type %v does not implement %v", typ, iface))
+	}
+	if caseStruct(typ).Kind() != reflect.Struct {
+		panic(fmt.Sprintf("structex: RegisterCase type %v is not a structure", typ))
+	}
+
+	unionsLock.Lock()
+	defer unionsLock.Unlock()
+
+	cases := unions[iface]
+	i := sort.Search(len(cases), func(i int) bool { return cases[i].value >= value })
+	if i < len(cases) && cases[i].value == value {
+		cases[i].typ = typ
+		return
+	}
+
+	cases = append(cases, unionCase{})
+	copy(cases[i+1:], cases[i:])
+	cases[i] = unionCase{value: value, typ: typ}
+	unions[iface] = cases
+}
+
+// casesOf returns the cases registered for iface, ordered by value.
+func casesOf(iface reflect.Type) []unionCase {
+	unionsLock.RLock()
+	defer unionsLock.RUnlock()
+
+	return append([]unionCase(nil), unions[iface]...)
+}
+
+// lookupCase returns the type registered for value of iface.
+func lookupCase(iface reflect.Type, value uint64) (reflect.Type, bool) {
+	for _, c := range casesOf(iface) {
+		if c.value == value {
+			return c.typ, true
+		}
+	}
+	return nil, false
+}
+
+// caseValue returns the discriminator value of the concrete type held by
+// the union, preferring current if it is one of several.
+func caseValue(union reflect.Value, current uint64) (uint64, error) {
+	if union.IsNil() {
+		return 0, fmt.Errorf("Union of type %s is nil: %w", union.Type().String(), ErrUnknownCase)
+	}
+
+	typ := union.Elem().Type()
+
+	found, value := false, uint64(0)
+	for _, c := range casesOf(union.Type()) {
+		if c.typ != typ {
+			continue
+		}
+		if c.value == current {
+			return current, nil
+		}
+		if !found {
+			found, value = true, c.value
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("No case of %s registered for type %s: %w", union.Type().String(), typ.String(), ErrUnknownCase)
+	}
+
+	return value, nil
+}
+
+// caseStruct returns the structure type of a case.
+func caseStruct(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Ptr {
+		return typ.Elem()
+	}
+	return typ
+}
+
+func (e *encoder) discriminator(val reflect.Value, tags *tags, union reflect.Value) error {
+	value, err := getValue(val)
+	if err != nil {
+		return err
+	}
+	value, err = caseValue(union, value)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.New(val.Type()).Elem()
+	if isSigned(v.Kind()) {
+		v.SetInt(int64(value))
+	} else {
+		v.SetUint(value)
+	}
+
+	return e.field(v, tags)
+}
+
+func (e *encoder) union(t *transcoder, val reflect.Value, tags *tags) error {
+	if val.IsNil() {
+		return fmt.Errorf("Union of type %s is nil: %w", val.Type().String(), ErrUnknownCase)
+	}
+
+	return t.transcode(val.Elem(), tags)
+}
+
+func (d *decoder) discriminator(val reflect.Value, tags *tags, union reflect.Value) error {
+	return d.field(val, tags)
+}
+
+func (d *decoder) union(t *transcoder, val reflect.Value, tags *tags) error {
+	disc, _ := t.fieldByName(tags.union)
+	value, err := getValue(disc)
+	if err != nil {
+		return err
+	}
+
+	typ, ok := lookupCase(val.Type(), value)
+	if !ok {
+		return fmt.Errorf("No case of %s registered for %s value %#x: %w", val.Type().String(), tags.union, value, ErrUnknownCase)
+	}
+
+	ptr := reflect.New(caseStruct(typ))
+	if err := t.transcode(ptr, tags); err != nil {
+		return err
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		val.Set(ptr)
+	} else {
+		val.Set(ptr.Elem())
+	}
+
+	return nil
+}
+
+func (s *sizer) discriminator(val reflect.Value, tags *tags, union reflect.Value) error {
+	return s.field(val, tags)
+}
+
+// union sizes the case held by the union; a nil union occupies no space.
+func (s *sizer) union(t *transcoder, val reflect.Value, tags *tags) error {
+	if val.IsNil() {
+		return nil
+	}
+
+	return t.transcode(val.Elem(), tags)
+}
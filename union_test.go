@@ -0,0 +1,193 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type unionPage interface {
+	isUnionPage()
+}
+
+type unionSerial struct {
+	Serial [4]byte
+}
+
+type unionDevice struct {
+	ID    uint16 `big:""`
+	Flags uint8
+}
+
+type unionOther struct{}
+
+type unionCode uint8
+
+func (unionSerial) isUnionPage()  {}
+func (*unionDevice) isUnionPage() {}
+func (unionOther) isUnionPage()   {}
+func (unionCode) isUnionPage()    {}
+
+type unionVPD struct {
+	Qualifier uint8
+	PageCode  uint8
+	Page      unionPage `switch:"PageCode"`
+	Trailer   uint8
+}
+
+func init() {
+	iface := reflect.TypeOf((*unionPage)(nil)).Elem()
+
+	RegisterCase(iface, 0x80, reflect.TypeOf(unionSerial{}))
+	RegisterCase(iface, 0x84, reflect.TypeOf(&unionDevice{}))
+	RegisterCase(iface, 0x83, reflect.TypeOf(&unionDevice{}))
+}
+
+func TestUnion(t *testing.T) {
+	tests := []struct {
+		s unionVPD
+		b []byte
+		d uint8 // Decoded page code
+	}{
+		{
+			unionVPD{Qualifier: 1, Page: unionSerial{[4]byte{'A', 'B', 'C', 'D'}}, Trailer: 9},
+			[]byte{0x01, 0x80, 'A', 'B', 'C', 'D', 0x09},
+			0x80,
+		},
+		{
+			unionVPD{PageCode: 0x84, Page: &unionDevice{ID: 0x1234, Flags: 5}},
+			[]byte{0x00, 0x84, 0x12, 0x34, 0x05, 0x00},
+			0x84,
+		},
+		{
+			unionVPD{PageCode: 0x80, Page: &unionDevice{ID: 0x1234, Flags: 5}},
+			[]byte{0x00, 0x83, 0x12, 0x34, 0x05, 0x00},
+			0x83,
+		},
+	}
+
+	for _, test := range tests {
+		b, err := EncodeByteBuffer(test.s)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		if !bytes.Equal(b, test.b) {
+			t.Errorf("Invalid union encoding:\nExpected: %#02x\nActual:   %#02x", test.b, b)
+		}
+
+		var s unionVPD
+		if err := Decode(bytes.NewReader(test.b), &s); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+
+		test.s.PageCode = test.d
+		if !reflect.DeepEqual(s, test.s) {
+			t.Errorf("Invalid union decode:\nExpected: %+v\nActual:   %+v", test.s, s)
+		}
+	}
+
+	// A nil union occupies no space
+	if sz, err := Size(unionVPD{}); err != nil || sz != 3 {
+		t.Errorf("Invalid size of nil union: Expected: 3 Actual: %d %v", sz, err)
+	}
+
+	if errs := Validate(unionVPD{}); len(errs) != 0 {
+		t.Errorf("Unexpected validation errors: %v", errs)
+	}
+}
+
+func TestUnionError(t *testing.T) {
+	var s unionVPD
+	err := Decode(bytes.NewReader([]byte{0x00, 0x99, 0x00, 0x00}), &s)
+
+	var fe *FieldError
+	if !errors.Is(err, ErrUnknownCase) || !errors.As(err, &fe) || fe.Path != "Page" {
+		t.Errorf("Expected unknown case decoding Page: Actual: %v", err)
+	}
+
+	for _, page := range []unionPage{nil, unionOther{}, &unionSerial{}} {
+		err := Encode(new(bytes.Buffer), unionVPD{Page: page})
+		if !errors.Is(err, ErrUnknownCase) || !errors.As(err, &fe) || fe.Path != "PageCode" {
+			t.Errorf("Expected unknown case encoding %T: Actual: %v", page, err)
+		}
+	}
+
+	tests := []interface{}{
+		struct {
+			V uint8
+			A uint8 `switch:"V"`
+		}{},
+		struct {
+			A unionPage `switch:"Missing"`
+		}{},
+		struct {
+			A unionPage `switch:"V"`
+			V uint8
+		}{},
+		struct {
+			V [2]uint8
+			A unionPage `switch:"V"`
+		}{},
+		struct {
+			V uint8
+			A unionPage
+		}{},
+	}
+
+	for _, test := range tests {
+		if err := Encode(new(bytes.Buffer), test); !errors.Is(err, ErrTag) {
+			t.Errorf("Expected tagging error for %T: Actual: %v", test, err)
+		}
+		if errs := Validate(test); len(errs) == 0 {
+			t.Errorf("Expected validation error for %T", test)
+		}
+	}
+}
+
+func TestRegisterCasePanics(t *testing.T) {
+	iface := reflect.TypeOf((*unionPage)(nil)).Elem()
+
+	tests := []struct {
+		iface reflect.Type
+		typ   reflect.Type
+	}{
+		{reflect.TypeOf(unionSerial{}), reflect.TypeOf(unionSerial{})},
+		{iface, reflect.TypeOf(unionDevice{})},
+		{iface, reflect.TypeOf(unionCode(0))},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic registering %v for %v", test.typ, test.iface)
+				}
+			}()
+			RegisterCase(test.iface, 0xFF, test.typ)
+		}()
+	}
+}
@@ -0,0 +1,386 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+package structex
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/HewlettPackard/structex/internal/tag"
+)
+
+/*
+Validate statically checks the annotations of the structure type of v, or of
+the structure v points to, without transcoding any data. Every problem found
+is reported, not only the first, each naming the offending field and
+wrapping ErrTag. Validate returns nil for a valid structure.
+
+Reported problems include annotations that cannot be parsed, unknown keys
+in the full `structex:"..."` format, bitfields wider than their type,
+sizeOf and countOf annotations referencing missing or non-array fields,
+conditions referencing missing, later or non-integer fields, unions
+without a preceding integer discriminator or registered cases, offsets
+held by missing, later or non-integer fields,
+alignment of fields that do not start on a byte boundary, unexported fields,
+unsupported field types and structures that do not end on a byte boundary.
+
+Types implementing Marshaler, Unmarshaler or Sizer describe their own
+layout and are assumed to occupy whole bytes.
+*/
+func Validate(v interface{}) []error {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		return []error{fmt.Errorf("Cannot validate nil value: %w", ErrTag)}
+	}
+
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return []error{fmt.Errorf("Cannot validate type %s; must be a structure: %w", typ.String(), ErrTag)}
+	}
+
+	vd := validator{active: make(map[reflect.Type]bool)}
+	vd.structure(typ, typ.Name())
+
+	if vd.bits%8 != 0 {
+		vd.errorf(typ.Name(), "%d left-over bits in structure definition", vd.bits%8)
+	}
+
+	return vd.errs
+}
+
+type validator struct {
+	errs   []error
+	bits   uint64                // Bits laid out so far, excluding types of unknown size
+	stack  []reflect.Type        // Enclosing structures, innermost last
+	active map[reflect.Type]bool // Structures on the stack, guarding against recursion
+}
+
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s: %w", path, fmt.Sprintf(format, args...), ErrTag))
+}
+
+func (v *validator) structure(typ reflect.Type, path string) {
+	if v.active[typ] {
+		return
+	}
+
+	v.active[typ] = true
+	v.stack = append(v.stack, typ)
+	defer func() {
+		delete(v.active, typ)
+		v.stack = v.stack[:len(v.stack)-1]
+	}()
+
+	for i := 0; i < typ.NumField(); i++ {
+		v.field(typ, i, path+"."+typ.Field(i).Name)
+	}
+}
+
+func (v *validator) field(typ reflect.Type, index int, path string) {
+	sf := typ.Field(index)
+
+	tags, err := parseFieldTags(sf)
+	if err != nil {
+		v.errs = append(v.errs, fmt.Errorf("%s: %w", path, err))
+		return
+	}
+
+	// Bare tags share the field tag with other packages, so only the keys
+	// of the full format are known to be meant for structex.
+	if tag.IsFull(sf.Tag) {
+		for _, attr := range tag.Parse(sf.Tag) {
+			if !tag.IsKey(attr.Key) {
+				v.errorf(path, "unknown annotation '%s'", attr.Key)
+			}
+		}
+	}
+
+	if sf.PkgPath != "" && sf.Name != "_" {
+		v.errorf(path, "field is unexported")
+	}
+
+	// Fields at an offset are laid out on their own
+	if len(tags.offset.name) != 0 {
+		v.offset(typ, index, &tags, path)
+
+		start := v.bits
+		defer func() { v.bits = start }()
+		v.bits = 0
+	}
+
+	if tags.alignment != 0 {
+		if v.bits%8 != 0 {
+			v.errorf(path, "aligned field starts at bit %d of a byte", v.bits%8)
+		}
+		v.bits = (v.bits + 7) / 8 * 8
+	}
+
+	if tags.layout.format != none {
+		v.layout(typ, index, &tags, path)
+	}
+
+	if tags.cond != nil {
+		v.condition(typ, index, tags.cond, path)
+	}
+
+	if tags.greedy && index != typ.NumField()-1 {
+		v.errorf(path, "greedy slice must be the last field")
+	}
+
+	// Pointers are laid out as the value they point to
+	for sf.Type.Kind() == reflect.Ptr {
+		sf.Type = sf.Type.Elem()
+	}
+
+	if isCustom(sf.Type) {
+		return
+	}
+
+	switch sf.Type.Kind() {
+	case reflect.Struct:
+		v.structure(sf.Type, path)
+
+	case reflect.Interface:
+		v.union(typ, index, &tags, path)
+
+	case reflect.Array, reflect.Slice:
+		start := v.bits
+		v.element(sf.Type.Elem(), &tags, path)
+		nbits := v.bits - start
+
+		if sf.Type.Kind() == reflect.Array {
+			v.bits = start + nbits*uint64(sf.Type.Len())
+		} else if nbits%8 != 0 {
+			v.errorf(path, "slice elements of %d bits do not end on a byte boundary", nbits)
+		}
+
+	default:
+		v.bits += v.scalar(sf.Type, &tags, path)
+	}
+}
+
+// element lays out a single element of an array or slice field.
+func (v *validator) element(typ reflect.Type, tags *tags, path string) {
+	if isCustom(typ) {
+		return
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		v.structure(typ, path)
+	case reflect.Array, reflect.Slice:
+		v.errorf(path, "unsupported element type %s", typ.String())
+	default:
+		v.bits += v.scalar(typ, tags, path)
+	}
+}
+
+// scalar returns the number of bits occupied by a value of typ annotated
+// with tags.
+func (v *validator) scalar(typ reflect.Type, tags *tags, path string) uint64 {
+	nbits := tags.bitfield.nbits
+
+	switch typ.Kind() {
+	case reflect.Bool, reflect.String:
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if nbits == 0 {
+			nbits = uint64(typ.Bits())
+		}
+		if nbits > uint64(typ.Bits()) {
+			v.errorf(path, "bitfield of %d bits exceeds %d bit type %s", nbits, typ.Bits(), typ.String())
+		}
+
+	default:
+		v.errorf(path, "unsupported type %s", typ.String())
+		return 0
+	}
+
+	if nbits == 0 {
+		v.errorf(path, "zero width field")
+	}
+
+	return nbits
+}
+
+// layout checks the field referenced by a sizeOf or countOf annotation on
+// field index of typ. References resolve to the innermost enclosing
+// structure declaring the name.
+func (v *validator) layout(typ reflect.Type, index int, tags *tags, path string) {
+	switch typ.Field(index).Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		v.errorf(path, "layout field must be of integer type")
+	}
+
+	name := tags.layout.name
+
+	if name == restOf {
+		if tags.layout.format != sizeOf {
+			v.errorf(path, "remainder of the structure can only be given by sizeOf")
+		}
+		return
+	}
+
+	for i := len(v.stack); i != 0; i-- {
+		st := v.stack[i-1]
+
+		for j := 0; j < st.NumField(); j++ {
+			sf := st.Field(j)
+			if sf.Name != name {
+				continue
+			}
+
+			if k := sf.Type.Kind(); !isLayoutTarget(k, tags.layout.format) {
+				v.errorf(path, "referenced layout '%s' must be of type slice or array; is of type %s", name, k.String())
+			} else if k == reflect.Slice && st == typ && j < index {
+				v.errorf(path, "layout must precede the referenced slice '%s'", name)
+			} else if !isArray(k) && (st != typ || j < index) {
+				v.errorf(path, "sizeOf must precede the structure '%s' in the same structure", name)
+			}
+
+			if tags.layout.origin != originNone && (st != typ || j < index) {
+				v.errorf(path, "sizeOf from an origin must precede '%s' in the same structure", name)
+			}
+
+			return
+		}
+	}
+
+	v.errorf(path, "cannot locate referenced field '%s'", name)
+}
+
+// condition checks the field referenced by an if annotation on field index
+// of typ is declared before it and holds an integer or bool.
+func (v *validator) condition(typ reflect.Type, index int, c *condition, path string) {
+	name := c.path[0]
+
+	for i := len(v.stack); i != 0; i-- {
+		st := v.stack[i-1]
+
+		sf, ok := st.FieldByName(name)
+		if !ok {
+			continue
+		}
+
+		if st == typ && sf.Index[0] >= index {
+			v.errorf(path, "condition must follow the referenced field '%s'", name)
+			return
+		}
+
+		ft := sf.Type
+		for _, name := range c.path[1:] {
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() != reflect.Struct {
+				v.errorf(path, "condition references '%s' of non-structure type %s", name, ft.String())
+				return
+			}
+			if sf, ok = ft.FieldByName(name); !ok {
+				v.errorf(path, "cannot locate field '%s' referenced by condition", name)
+				return
+			}
+			ft = sf.Type
+		}
+
+		switch ft.Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			v.errorf(path, "condition references field of unsupported type %s", ft.String())
+		}
+
+		return
+	}
+
+	v.errorf(path, "cannot locate field '%s' referenced by condition", name)
+}
+
+// union checks the discriminator of an interface field index of typ, and
+// lays out each case registered for it on its own.
+func (v *validator) union(typ reflect.Type, index int, tags *tags, path string) {
+	sf := typ.Field(index)
+
+	if len(tags.union) == 0 {
+		v.errorf(path, "interface field requires a switch annotation")
+		return
+	}
+
+	if d, ok := typ.FieldByName(tags.union); !ok || len(d.Index) != 1 || d.Index[0] >= index || !isInteger(d.Type.Kind()) {
+		v.errorf(path, "union must follow the integer field '%s' of the same structure", tags.union)
+	}
+
+	cases := casesOf(sf.Type)
+	if len(cases) == 0 {
+		v.errorf(path, "no cases registered for union of type %s", sf.Type.String())
+	}
+
+	start := v.bits
+	for _, c := range cases {
+		typ := caseStruct(c.typ)
+		if isCustom(typ) {
+			continue
+		}
+
+		v.bits = 0
+		v.structure(typ, fmt.Sprintf("%s(%s)", path, typ.Name()))
+
+		if v.bits%8 != 0 {
+			v.errorf(path, "case %s has %d left-over bits", typ.Name(), v.bits%8)
+		}
+	}
+	v.bits = start
+}
+
+// offset checks the field holding the offset of field index of typ.
+// References resolve to the innermost enclosing structure declaring the
+// name.
+func (v *validator) offset(typ reflect.Type, index int, tags *tags, path string) {
+	name := tags.offset.name
+
+	for i := len(v.stack); i != 0; i-- {
+		st := v.stack[i-1]
+
+		sf, ok := st.FieldByName(name)
+		if !ok {
+			continue
+		}
+
+		if st == typ && sf.Index[0] >= index {
+			v.errorf(path, "offset must follow the referenced field '%s'", name)
+		} else if !isInteger(sf.Type.Kind()) {
+			v.errorf(path, "referenced offset '%s' must be of integer type; is of type %s", name, sf.Type.Kind().String())
+		}
+
+		return
+	}
+
+	v.errorf(path, "cannot locate referenced field '%s'", name)
+}
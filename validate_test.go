@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Hewlett Packard Enterprise Development LP
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction, including without limitation
+the rights to use, copy, modify, merge, publish, distribute, sublicense,
+and/or sell copies of the Software, and to permit persons to whom the
+Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE
+USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package structex
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type validDescriptor struct {
+	Type   uint8 `bitfield:"4"`
+	Flags  uint8 `bitfield:"4"`
+	Length uint16
+}
+
+type validIdentify struct {
+	_           struct{} `bitorder:"lsb"`
+	Version     uint8
+	Count       uint8   `countOf:"Descriptors"`
+	Size        uint8   `sizeOf:"Data"`
+	Name        string  `string:"8,pad=space"`
+	Ratio       float32 `float:"half"`
+	Enabled     bool
+	Mode        uint8 `bitfield:"7"`
+	Descriptors []validDescriptor
+	Data        []uint8 `structex:"align=4"`
+	Reserved    [2]uint32
+}
+
+func TestValidate(t *testing.T) {
+	if errs := Validate(validIdentify{}); errs != nil {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+
+	if errs := Validate(&validIdentify{}); errs != nil {
+		t.Errorf("Unexpected errors for pointer: %v", errs)
+	}
+
+	if errs := Validate(uint8(0)); len(errs) != 1 {
+		t.Errorf("Expected error for non-structure type: Actual: %v", errs)
+	}
+}
+
+type invalidNested struct {
+	A uint8 `bitfield:"9"`
+	B uint8 `bitfield:"x"`
+}
+
+type invalidStruct struct {
+	Nested  invalidNested
+	C       uint8  `structex:"bitfeld='3'"`
+	D       uint8  `sizeOf:"Missing"`
+	E       uint8  `countOf:"C"`
+	F       uint8  `bitfield:"3"`
+	G       uint32 `align:"4"`
+	h       uint8
+	I       complex64
+	M       map[string]int
+	Items   []uint8
+	N       uint8 `countOf:"Items"`
+	Partial uint8 `bitfield:"2"`
+}
+
+func TestValidateErrors(t *testing.T) {
+	errs := Validate(invalidStruct{})
+
+	expected := []string{
+		"invalidStruct.Nested.A: Invalid tag",
+		"invalidStruct.Nested.B: Invalid tag",
+		"invalidStruct.C: unknown annotation 'bitfeld'",
+		"invalidStruct.D: cannot locate referenced field 'Missing'",
+		"invalidStruct.E: referenced layout 'C' must be of type slice or array",
+		"invalidStruct.G: aligned field starts at bit",
+		"invalidStruct.h: field is unexported",
+		"invalidStruct.I: Invalid tag '' for complex64",
+		"invalidStruct.M: Invalid tag '' for map",
+		"invalidStruct.N: layout must precede the referenced slice 'Items'",
+		"invalidStruct: 2 left-over bits",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Invalid number of errors: Expected: %d Actual: %d %v", len(expected), len(errs), errs)
+	}
+
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), expected[i]) {
+			t.Errorf("Unexpected error: Expected: %s Actual: %v", expected[i], err)
+		}
+
+		if !errors.Is(err, ErrTag) {
+			t.Errorf("Expected error to match ErrTag: %v", err)
+		}
+	}
+}